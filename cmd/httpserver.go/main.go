@@ -1,63 +1,106 @@
 package main
 
 import (
-	"crypto/sha256"
+	"context"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"os"
 	"os/signal"
-	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
-	"github.com/TheBarnakhil/httpfromtcp/internal/headers"
+	"github.com/TheBarnakhil/httpfromtcp/internal/client"
+	"github.com/TheBarnakhil/httpfromtcp/internal/middleware"
+	"github.com/TheBarnakhil/httpfromtcp/internal/proxy"
 	"github.com/TheBarnakhil/httpfromtcp/internal/request"
 	"github.com/TheBarnakhil/httpfromtcp/internal/response"
+	"github.com/TheBarnakhil/httpfromtcp/internal/router"
 	"github.com/TheBarnakhil/httpfromtcp/internal/server"
 )
 
 const port = 42069
 
+// shutdownTimeout is how long in flight requests get to finish on SIGINT or SIGTERM
+const shutdownTimeout = 30 * time.Second
+
+/*
+defaultUpstream is where the /httpbin route forwards to, unless HTTPBIN_UPSTREAM names other servers.
+Several servers are separated by commas, requests are then balanced across those that respond.
+*/
+const defaultUpstream = "https://httpbin.org"
+
 func main() {
-	server, err := server.Serve(port, handler)
+	upstream := os.Getenv("HTTPBIN_UPSTREAM")
+	if upstream == "" {
+		upstream = defaultUpstream
+	}
+	pool, err := proxy.NewPool(strings.Split(upstream, ","))
+	if err != nil {
+		log.Fatalf("Error configuring the proxy: %v", err)
+	}
+	defer pool.Close()
+	httpbin := proxy.NewBalancer(pool,
+		proxy.WithStripPrefix("/httpbin"),
+		proxy.WithContentDigest(),
+		proxy.WithClient(client.New(client.WithTimeout(time.Minute))),
+	)
+
+	handler := server.Chain(newRouter(httpbin.Handler()).Handler(),
+		middleware.RequestID,
+		middleware.Logging,
+		middleware.Recover,
+	)
+	opts := []server.Option{
+		server.WithReadHeaderTimeout(10 * time.Second),
+		server.WithWriteTimeout(time.Minute),
+		server.WithIdleTimeout(2 * time.Minute),
+	}
+	// TLS_CERT_FILE and TLS_KEY_FILE switch the server to TLS, the files are read again on SIGHUP
+	serve := server.Serve
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	if certFile != "" && keyFile != "" {
+		serve = func(port int, handler server.Handler, opts ...server.Option) (*server.Server, error) {
+			return server.ServeTLS(port, handler, certFile, keyFile, opts...)
+		}
+	}
+	server, err := serve(port, handler, opts...)
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
-	defer server.Close()
 	log.Println("Server started on port", port)
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Error shutting down server: %v", err)
+	}
 	log.Println("Server gracefully stopped")
 }
 
-func handler(w *response.Writer, req *request.Request) {
-
-	if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin") {
-		proxyHandler(w, req)
-	}
+func newRouter(httpbin server.Handler) *router.Router {
+	rt := router.New()
+	rt.Handle("/yourproblem", handler400)
+	rt.Handle("/myproblem", handler500)
+	rt.Handle("GET /video", videoHandler)
+	rt.Handle("/httpbin/", httpbin)
+	rt.Handle("/", handler200)
+	return rt
+}
 
-	switch req.RequestLine.RequestTarget {
-	case "/yourproblem":
-		handler400(w, req)
-		return
-	case "/myproblem":
-		handler500(w, req)
-	case "/video":
-		content, err := os.ReadFile("../../assets/vim.mp4")
-		if err != nil {
-			fmt.Println("Error reading file: ", err)
-		}
-		w.WriteStatusLine(response.OK)
-		headers := response.GetDefaultHeaders(len(content), response.Video)
-		w.WriteHeaders(headers)
-		w.WriteBody([]byte(content))
-	default:
-		handler200(w, req)
+func videoHandler(w *response.Writer, _ *request.Request) {
+	content, err := os.ReadFile("../../assets/vim.mp4")
+	if err != nil {
+		fmt.Println("Error reading file: ", err)
 	}
+	w.WriteStatusLine(response.OK)
+	headers := response.GetDefaultHeaders(len(content), response.Video)
+	w.WriteHeaders(headers)
+	w.WriteBody([]byte(content))
 }
 
 func handler400(w *response.Writer, _ *request.Request) {
@@ -113,55 +156,3 @@ func handler200(w *response.Writer, _ *request.Request) {
 	w.WriteHeaders(headers)
 	w.WriteBody([]byte(content))
 }
-
-func proxyHandler(w *response.Writer, req *request.Request) {
-	route := strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin")
-
-	resp, err := http.Get("https://httpbin.org" + route)
-	if err != nil {
-		handler400(w, req)
-	}
-	defer resp.Body.Close()
-
-	w.WriteStatusLine(response.OK)
-	h := response.GetDefaultHeaders(0, response.Plain)
-	delete(h, "Content-Length")
-	h["Transfer-Encoding"] = "chunked"
-	err = w.WriteHeaders(h)
-	if err != nil {
-		fmt.Println("error in writing headers: ", err)
-		return
-	}
-
-	buff := make([]byte, 1024)
-	var body []byte
-	for {
-		n, err := resp.Body.Read(buff)
-		body = append(body, buff[:n]...)
-		fmt.Println("Read", n, "bytes")
-		if n > 0 {
-			_, err := w.WriteChunkedBody(buff[:n])
-			if err != nil {
-				fmt.Println("Error writing chunked body: ", err)
-				return
-			}
-		}
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			fmt.Println("Error reading response body:", err)
-			break
-		}
-	}
-	_, err = w.WriteChunkedBodyDone()
-	if err != nil {
-		fmt.Println("Error writing chunked body done: ", err)
-	}
-
-	h = headers.NewHeaders()
-	h["Trailer"] = "X-Content-SHA256, X-Content-Length"
-	h["X-Content-SHA256"] = fmt.Sprintf("%x", sha256.Sum256(body))
-	h["X-Content-Length"] = strconv.Itoa(len(body))
-	w.WriteTrailers(h)
-}
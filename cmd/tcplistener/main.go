@@ -33,7 +33,7 @@ func main() {
 	fmt.Println("- Target:", req.RequestLine.RequestTarget)
 	fmt.Println("- Version:", req.RequestLine.HttpVersion)
 	fmt.Println("Headers:")
-	for key, val := range req.Headers {
+	for key, val := range req.Headers.All() {
 		fmt.Printf("- %s: %s\n", key, val)
 	}
 	fmt.Println("Body:")
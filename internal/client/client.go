@@ -0,0 +1,280 @@
+package client
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/TheBarnakhil/httpfromtcp/internal/request"
+	"github.com/TheBarnakhil/httpfromtcp/internal/response"
+)
+
+const crlf = "\r\n"
+
+// bufferSize is the size of the buffered reader and writer wrapped around a connection.
+const bufferSize = 8192
+
+/*
+Client sends HTTP/1.1 requests and keeps connections alive to reuse them for
+later requests to the same host. It is safe for concurrent use.
+*/
+type Client struct {
+	// DialTimeout bounds connecting to a server, including the TLS handshake
+	DialTimeout time.Duration
+	// Timeout bounds a whole exchange, from sending the request until the response body is read
+	Timeout time.Duration
+	// IdleTimeout is how long an unused connection is kept for the next request to its host
+	IdleTimeout time.Duration
+	// MaxIdleConnsPerHost bounds how many unused connections are kept per host
+	MaxIdleConnsPerHost int
+	// TLSConfig is used to connect to https URLs
+	TLSConfig *tls.Config
+
+	mu sync.Mutex
+	// unused connections by scheme and address, the most recently used last
+	idle map[string][]*conn
+}
+
+type conn struct {
+	net.Conn
+	key       string
+	br        *bufio.Reader
+	bw        *bufio.Writer
+	idleSince time.Time
+}
+
+func New(opts ...Option) *Client {
+	c := &Client{
+		DialTimeout:         30 * time.Second,
+		IdleTimeout:         90 * time.Second,
+		MaxIdleConnsPerHost: 2,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Get sends a GET request for rawURL.
+func (c *Client) Get(rawURL string) (*response.Response, error) {
+	req, err := NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+/*
+Do sends req and reads the status line and headers of the response, 1xx responses are skipped.
+The body is streamed from the connection as it is read, and the caller has to close the BodyReader.
+Once it was read to the end the connection goes back to the pool, if the server allows that.
+
+An idempotent request without a body that fails on a pooled connection before any byte
+of the response arrived, as the server may have closed the connection in the meantime,
+is retried once on a new connection. A request that timed out is not, the server
+may still be working on it.
+*/
+func (c *Client) Do(req *Request) (*response.Response, error) {
+	cn, reused, err := c.getConn(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.roundTrip(cn, req)
+	if err != nil && reused && canRetry(req, err) {
+		if cn, err = c.dial(req); err != nil {
+			return nil, err
+		}
+		resp, err = c.roundTrip(cn, req)
+	}
+	return resp, err
+}
+
+func (c *Client) roundTrip(cn *conn, req *Request) (*response.Response, error) {
+	if c.Timeout > 0 {
+		cn.SetDeadline(time.Now().Add(c.Timeout))
+	} else {
+		cn.SetDeadline(time.Time{})
+	}
+	if err := req.write(cn.bw); err != nil {
+		cn.Close()
+		return nil, &noResponseError{err}
+	}
+	if _, err := cn.br.Peek(1); err != nil {
+		cn.Close()
+		return nil, &noResponseError{err}
+	}
+	resp, err := readResponse(cn.br, req.Method)
+	if err != nil {
+		cn.Close()
+		return nil, err
+	}
+	reusable := resp.KeepAlive() && !req.Headers.HasToken("Connection", "close")
+
+	b := &body{reader: resp.BodyReader, release: func(ok bool) {
+		if ok && reusable {
+			c.putConn(cn)
+		} else {
+			cn.Close()
+		}
+	}}
+	if resp.BodyReader == response.NoBody {
+		// nothing to read, the connection can be released right away
+		b.finish(true)
+	}
+	resp.BodyReader = b
+	return resp, nil
+}
+
+// noResponseError is an error that happened before any byte of the response arrived.
+type noResponseError struct {
+	err error
+}
+
+func (e *noResponseError) Error() string {
+	return e.err.Error()
+}
+
+func (e *noResponseError) Unwrap() error {
+	return e.err
+}
+
+// canRetry reports whether req can be sent again after it failed with err.
+func canRetry(req *Request, err error) bool {
+	var noResponse *noResponseError
+	return req.Body == nil && request.IsIdempotent(req.Method) &&
+		errors.As(err, &noResponse) && !errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+// readResponse reads the response to a request with method, skipping interim 1xx responses.
+func readResponse(br *bufio.Reader, method string) (*response.Response, error) {
+	for {
+		resp, err := response.StreamingResponseFromReader(br, method)
+		if err != nil {
+			return nil, err
+		}
+		code := resp.StatusLine.StatusCode
+		if code >= 100 && code < 200 && code != response.SwitchingProtocols {
+			continue
+		}
+		return resp, nil
+	}
+}
+
+// getConn returns an idle connection to the request's host, or a new one.
+func (c *Client) getConn(req *Request) (*conn, bool, error) {
+	key := req.URL.Scheme + "://" + req.addr()
+	c.mu.Lock()
+	for len(c.idle[key]) > 0 {
+		conns := c.idle[key]
+		cn := conns[len(conns)-1]
+		c.idle[key] = conns[:len(conns)-1]
+		if c.IdleTimeout > 0 && time.Since(cn.idleSince) > c.IdleTimeout {
+			cn.Close()
+			continue
+		}
+		c.mu.Unlock()
+		return cn, true, nil
+	}
+	c.mu.Unlock()
+	cn, err := c.dial(req)
+	return cn, false, err
+}
+
+func (c *Client) dial(req *Request) (*conn, error) {
+	addr := req.addr()
+	dialer := &net.Dialer{Timeout: c.DialTimeout}
+	var nc net.Conn
+	var err error
+	if req.URL.Scheme == "https" {
+		config := c.TLSConfig.Clone()
+		if config == nil {
+			config = &tls.Config{}
+		}
+		if config.ServerName == "" {
+			config.ServerName = req.URL.Hostname()
+		}
+		nc, err = (&tls.Dialer{NetDialer: dialer, Config: config}).Dial("tcp", addr)
+	} else {
+		nc, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &conn{
+		Conn: nc,
+		key:  req.URL.Scheme + "://" + addr,
+		br:   bufio.NewReaderSize(nc, bufferSize),
+		bw:   bufio.NewWriterSize(nc, bufferSize),
+	}, nil
+}
+
+// putConn keeps cn for the next request to its host, or closes it if enough are kept already.
+func (c *Client) putConn(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.idle == nil {
+		c.idle = make(map[string][]*conn)
+	}
+	if len(c.idle[cn.key]) >= c.MaxIdleConnsPerHost {
+		cn.Close()
+		return
+	}
+	cn.SetDeadline(time.Time{})
+	cn.idleSince = time.Now()
+	c.idle[cn.key] = append(c.idle[cn.key], cn)
+}
+
+// CloseIdleConnections closes the connections kept for later requests.
+func (c *Client) CloseIdleConnections() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, conns := range c.idle {
+		for _, cn := range conns {
+			cn.Close()
+		}
+		delete(c.idle, key)
+	}
+}
+
+/*
+body is the BodyReader of a response. It releases the connection once the body was read
+to the end, or closes it if the body is closed early or could not be read.
+*/
+type body struct {
+	reader  io.Reader
+	release func(ok bool)
+	done    bool
+	err     error
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	n, err := b.reader.Read(p)
+	if err != nil {
+		b.err = err
+		b.finish(errors.Is(err, io.EOF))
+	}
+	return n, err
+}
+
+func (b *body) Close() error {
+	if b.err == nil {
+		b.err = errors.New("error: read on closed response body")
+	}
+	b.finish(false)
+	return nil
+}
+
+func (b *body) finish(ok bool) {
+	if !b.done {
+		b.done = true
+		b.release(ok)
+	}
+}
@@ -0,0 +1,245 @@
+package client
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/TheBarnakhil/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	var conns atomic.Int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/length":
+			w.Header().Set("Content-Length", "5")
+			io.WriteString(w, "hello")
+		case "/chunked":
+			w.Header().Set("Trailer", "X-Checksum")
+			io.WriteString(w, "hello ")
+			w.(http.Flusher).Flush()
+			io.WriteString(w, "world")
+			w.Header().Set("X-Checksum", "abc")
+		case "/echo":
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("X-Method", r.Method)
+			w.Header().Set("X-Trailer", r.Trailer.Get("X-Checksum"))
+			w.Header().Set("X-Chunked", strings.Join(r.TransferEncoding, ","))
+			w.Write(body)
+		case "/empty":
+			w.WriteHeader(http.StatusNoContent)
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		}
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+	c := New(WithTimeout(100 * time.Millisecond))
+	defer c.CloseIdleConnections()
+
+	// Test: Body delimited by Content-Length
+	resp, err := c.Get(srv.URL + "/length")
+	require.NoError(t, err)
+	assert.Equal(t, response.OK, resp.StatusLine.StatusCode)
+	assert.Equal(t, "OK", resp.StatusLine.ReasonPhrase)
+	body, err := io.ReadAll(resp.BodyReader)
+	require.NoError(t, err)
+	resp.BodyReader.Close()
+	assert.Equal(t, "hello", string(body))
+
+	// Test: Chunked body with trailers, on the same connection
+	resp, err = c.Get(srv.URL + "/chunked")
+	require.NoError(t, err)
+	body, err = io.ReadAll(resp.BodyReader)
+	require.NoError(t, err)
+	resp.BodyReader.Close()
+	assert.Equal(t, "hello world", string(body))
+	checksum, _ := resp.Trailers.Get("X-Checksum")
+	assert.Equal(t, "abc", checksum)
+	assert.Equal(t, int32(1), conns.Load())
+
+	// Test: Request bodies with a known and an unknown length
+	req, err := NewRequest("PUT", srv.URL+"/echo", strings.NewReader("known"))
+	require.NoError(t, err)
+	resp, err = c.Do(req)
+	require.NoError(t, err)
+	body, _ = io.ReadAll(resp.BodyReader)
+	resp.BodyReader.Close()
+	assert.Equal(t, "known", string(body))
+	method, _ := resp.Headers.Get("X-Method")
+	assert.Equal(t, "PUT", method)
+
+	req, err = NewRequest("POST", srv.URL+"/echo", io.MultiReader(strings.NewReader("un"), strings.NewReader("known")))
+	require.NoError(t, err)
+	req.Headers.Set("Trailer", "X-Checksum")
+	req.Trailers.Set("X-Checksum", "def")
+	resp, err = c.Do(req)
+	require.NoError(t, err)
+	body, _ = io.ReadAll(resp.BodyReader)
+	resp.BodyReader.Close()
+	assert.Equal(t, "unknown", string(body))
+	chunked, _ := resp.Headers.Get("X-Chunked")
+	assert.Equal(t, "chunked", chunked)
+	trailer, _ := resp.Headers.Get("X-Trailer")
+	assert.Equal(t, "def", trailer)
+
+	// Test: Responses to HEAD and 204 have no body
+	req, err = NewRequest("HEAD", srv.URL+"/length", nil)
+	require.NoError(t, err)
+	resp, err = c.Do(req)
+	require.NoError(t, err)
+	body, _ = io.ReadAll(resp.BodyReader)
+	assert.Empty(t, body)
+	resp, err = c.Get(srv.URL + "/empty")
+	require.NoError(t, err)
+	assert.Equal(t, response.NoContent, resp.StatusLine.StatusCode)
+	assert.Equal(t, int32(1), conns.Load())
+
+	// Test: Timeout
+	_, err = c.Get(srv.URL + "/slow")
+	require.Error(t, err)
+}
+
+func TestRetry(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	var mu sync.Mutex
+	var received []string
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				for {
+					requestLine, err := br.ReadString('\n')
+					if err != nil {
+						return
+					}
+					for {
+						line, err := br.ReadString('\n')
+						if err != nil || line == "\r\n" {
+							break
+						}
+					}
+					mu.Lock()
+					received = append(received, strings.Fields(requestLine)[0]+" "+strings.Fields(requestLine)[1])
+					mu.Unlock()
+					switch strings.Fields(requestLine)[1] {
+					case "/hang":
+						io.Copy(io.Discard, br)
+						return
+					case "/close":
+						// close without telling the client, as a server timing out an idle connection does
+						io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+						return
+					default:
+						io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+					}
+				}
+			}()
+		}
+	}()
+	c := New(WithTimeout(100 * time.Millisecond))
+	defer c.CloseIdleConnections()
+	base := "http://" + listener.Addr().String()
+	do := func(method, path string) error {
+		req, err := NewRequest(method, base+path, nil)
+		require.NoError(t, err)
+		resp, err := c.Do(req)
+		if err != nil {
+			return err
+		}
+		io.ReadAll(resp.BodyReader)
+		return resp.BodyReader.Close()
+	}
+	requests := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		r := received
+		received = nil
+		return r
+	}
+
+	// Test: Idempotent request on a connection the server closed is retried
+	require.NoError(t, do("GET", "/close"))
+	require.NoError(t, do("GET", "/ok"))
+	assert.Equal(t, []string{"GET /close", "GET /ok"}, requests())
+
+	// Test: POST on a connection the server closed is not
+	require.NoError(t, do("GET", "/close"))
+	require.Error(t, do("POST", "/ok"))
+	assert.Equal(t, []string{"GET /close"}, requests())
+
+	// Test: POST timing out on a reused connection is sent once
+	require.NoError(t, do("GET", "/ok"))
+	start := time.Now()
+	err = do("POST", "/hang")
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	assert.Less(t, time.Since(start), 200*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, []string{"GET /ok", "POST /hang"}, requests())
+
+	// Test: Neither is a GET timing out
+	require.NoError(t, do("GET", "/ok"))
+	err = do("GET", "/hang")
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, []string{"GET /ok", "GET /hang"}, requests())
+}
+
+func TestReadUntilClose(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				for {
+					line, err := br.ReadString('\n')
+					if err != nil || line == "\r\n" {
+						break
+					}
+				}
+				io.WriteString(conn, "HTTP/1.1 100 Continue\r\n\r\n")
+				io.WriteString(conn, "HTTP/1.0 200 OK\r\nContent-Type: text/plain\r\n\r\nuntil the end")
+			}()
+		}
+	}()
+
+	// Test: Interim response is skipped, body without framing lasts until the connection closes
+	c := New()
+	resp, err := c.Get("http://" + listener.Addr().String() + "/")
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", resp.StatusLine.HttpVersion)
+	body, err := io.ReadAll(resp.BodyReader)
+	require.NoError(t, err)
+	assert.Equal(t, "until the end", string(body))
+	assert.Empty(t, c.idle)
+}
@@ -0,0 +1,44 @@
+package client
+
+import (
+	"crypto/tls"
+	"time"
+)
+
+// Option configures a Client when it is created.
+type Option func(*Client)
+
+// WithDialTimeout bounds how long connecting to a server may take.
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.DialTimeout = timeout
+	}
+}
+
+// WithTimeout bounds a whole exchange, from sending the request until the response body is read.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.Timeout = timeout
+	}
+}
+
+// WithIdleTimeout bounds how long an unused connection is kept for the next request.
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.IdleTimeout = timeout
+	}
+}
+
+// WithMaxIdleConnsPerHost bounds how many unused connections are kept per host.
+func WithMaxIdleConnsPerHost(n int) Option {
+	return func(c *Client) {
+		c.MaxIdleConnsPerHost = n
+	}
+}
+
+// WithTLSConfig sets the TLS configuration used for https URLs.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(c *Client) {
+		c.TLSConfig = config
+	}
+}
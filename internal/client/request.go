@@ -0,0 +1,162 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/TheBarnakhil/httpfromtcp/internal/headers"
+)
+
+// Request is a request to be sent by a Client.
+type Request struct {
+	Method string
+	URL    *url.URL
+	// Headers are sent as given, Host and the body framing are added by the client
+	Headers *headers.Headers
+	// Body is streamed to the server, it may be nil for a request without a body
+	Body io.Reader
+	/*
+		ContentLength is the number of bytes Body will deliver. If it is negative,
+		the length is unknown and the body is sent with chunked encoding.
+		A zero ContentLength with a non-nil Body means unknown as well.
+	*/
+	ContentLength int64
+	// Trailers are sent after a chunked body, they are read once Body has been read to the end
+	Trailers *headers.Headers
+}
+
+/*
+NewRequest builds a request for rawURL, which must be an absolute http or https URL.
+The ContentLength is set if body is a *bytes.Reader, *bytes.Buffer or *strings.Reader.
+*/
+func NewRequest(method, rawURL string, body io.Reader) (*Request, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("error: Invalid URL %q: %w", rawURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("error: Unsupported URL scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("error: URL %q has no host", rawURL)
+	}
+	req := &Request{
+		Method:   method,
+		URL:      u,
+		Headers:  headers.NewHeaders(),
+		Body:     body,
+		Trailers: headers.NewHeaders(),
+	}
+	if l, ok := body.(interface{ Len() int }); ok {
+		req.ContentLength = int64(l.Len())
+	}
+	return req, nil
+}
+
+// addr returns the host and port to connect to, with the default port of the scheme if the URL has none.
+func (r *Request) addr() string {
+	if r.URL.Port() != "" {
+		return r.URL.Host
+	}
+	if r.URL.Scheme == "https" {
+		return r.URL.Host + ":443"
+	}
+	return r.URL.Host + ":80"
+}
+
+// chunked reports whether the body is sent with chunked encoding.
+func (r *Request) chunked() bool {
+	return r.Body != nil && r.ContentLength <= 0
+}
+
+// write sends the request line, the headers and the body, framed by Content-Length or chunked encoding.
+func (r *Request) write(w *bufio.Writer) error {
+	target := r.URL.RequestURI()
+	if r.Method == "OPTIONS" && target == "/" && r.URL.Path == "" {
+		target = "*"
+	}
+	if strings.ContainsAny(r.Method+target, " \r\n") {
+		return errors.New("error: Invalid method or request target")
+	}
+	fmt.Fprintf(w, "%s %s HTTP/1.1\r\n", r.Method, target)
+
+	if !r.Headers.Has("Host") {
+		writeField(w, "Host", r.URL.Host)
+	}
+	for key, val := range r.Headers.All() {
+		if strings.ContainsAny(key, "\r\n") || strings.ContainsAny(val, "\r\n") {
+			return fmt.Errorf("error: Invalid header %q, it contains a line break", key)
+		}
+		if strings.EqualFold(key, "Content-Length") || strings.EqualFold(key, "Transfer-Encoding") {
+			// the framing follows from Body and ContentLength
+			continue
+		}
+		writeField(w, key, val)
+	}
+	switch {
+	case r.chunked():
+		writeField(w, "Transfer-Encoding", "chunked")
+	case r.Body != nil:
+		writeField(w, "Content-Length", strconv.FormatInt(r.ContentLength, 10))
+	case r.Method == "POST" || r.Method == "PUT" || r.Method == "PATCH":
+		// these are expected to carry a body, say that it is empty
+		writeField(w, "Content-Length", "0")
+	}
+	w.WriteString(crlf)
+
+	switch {
+	case r.chunked():
+		if err := writeChunked(w, r.Body); err != nil {
+			return err
+		}
+		for key, val := range r.Trailers.All() {
+			writeField(w, key, val)
+		}
+		w.WriteString(crlf)
+	case r.Body != nil:
+		n, err := io.Copy(w, io.LimitReader(r.Body, r.ContentLength))
+		if err != nil {
+			return err
+		}
+		if n != r.ContentLength {
+			return fmt.Errorf("error: Request body has %d bytes, but its ContentLength is %d", n, r.ContentLength)
+		}
+	}
+	return w.Flush()
+}
+
+// writeChunked sends body as a chunk per read, flushing each so the body streams, followed by the last chunk.
+func writeChunked(w *bufio.Writer, body io.Reader) error {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			fmt.Fprintf(w, "%x\r\n", n)
+			w.Write(buf[:n])
+			w.WriteString(crlf)
+			if ferr := w.Flush(); ferr != nil {
+				return ferr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := w.WriteString("0" + crlf)
+	return err
+}
+
+func writeField(w *bufio.Writer, key, val string) {
+	w.WriteString(headers.CanonicalKey(key))
+	w.WriteString(": ")
+	w.WriteString(val)
+	w.WriteString(crlf)
+}
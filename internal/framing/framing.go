@@ -0,0 +1,138 @@
+package framing
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/TheBarnakhil/httpfromtcp/internal/headers"
+)
+
+/*
+ParseContentLength parses the values of the Content-Length fields of a message,
+RFC 9112 section 6.3. Each has to be a plain decimal number, without a sign or
+other whitespace than around it, and if there are several of them, as separate
+fields or as a list, all have to be the same.
+*/
+func ParseContentLength(values []string) (int64, error) {
+	length := int64(-1)
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			part = strings.Trim(part, " \t")
+			if part == "" || strings.TrimLeft(part, "0123456789") != "" {
+				// strconv would also take a sign
+				return 0, fmt.Errorf("invalid Content-Length %q", value)
+			}
+			n, err := strconv.ParseInt(part, 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("Content-Length %q is out of range", value)
+			}
+			if length >= 0 && n != length {
+				return 0, fmt.Errorf("conflicting Content-Length values %q", values)
+			}
+			length = n
+		}
+	}
+	return length, nil
+}
+
+/*
+ParseChunkSize parses the hex size at the start of a chunk size line, dropping any chunk extensions.
+Only the whitespace RFC 9112 allows before an extension is skipped, nothing in front of the size.
+The extensions are ignored but have to follow their grammar, as a proxy that ends lines
+at a bare LF inside of one would see a different chunk.
+*/
+func ParseChunkSize(line []byte) (int64, error) {
+	sizeText, extensions, hasExtensions := bytes.Cut(line, []byte(";"))
+	if hasExtensions {
+		if err := checkChunkExtensions(string(extensions)); err != nil {
+			return 0, err
+		}
+	}
+	sizeText = bytes.TrimRight(sizeText, " \t")
+	if len(sizeText) == 0 {
+		return 0, errors.New("missing chunk size")
+	}
+	for _, char := range sizeText {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", rune(char)) {
+			return 0, fmt.Errorf("invalid chunk size (%s)", sizeText)
+		}
+	}
+	size, err := strconv.ParseInt(string(sizeText), 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid chunk size (%s)", sizeText)
+	}
+	return size, nil
+}
+
+/*
+checkChunkExtensions checks s, the chunk extensions after the first ";", against RFC 9112
+section 7.1.1: names are tokens, values tokens or quoted strings, separated by ";" with
+optional whitespace around the ";" and "=".
+*/
+func checkChunkExtensions(s string) error {
+	for {
+		s = strings.TrimLeft(s, " \t")
+		name := tokenPrefix(s)
+		if name == "" {
+			return fmt.Errorf("invalid chunk extension %q", s)
+		}
+		s = strings.TrimLeft(s[len(name):], " \t")
+		if strings.HasPrefix(s, "=") {
+			s = strings.TrimLeft(s[1:], " \t")
+			if strings.HasPrefix(s, `"`) {
+				n, err := quotedStringLength(s)
+				if err != nil {
+					return err
+				}
+				s = s[n:]
+			} else {
+				value := tokenPrefix(s)
+				if value == "" {
+					return fmt.Errorf("invalid chunk extension value %q", s)
+				}
+				s = s[len(value):]
+			}
+			s = strings.TrimLeft(s, " \t")
+		}
+		if s == "" {
+			return nil
+		}
+		if s[0] != ';' {
+			return fmt.Errorf("invalid chunk extension %q", s)
+		}
+		s = s[1:]
+	}
+}
+
+// tokenPrefix returns the longest prefix of s that is a token.
+func tokenPrefix(s string) string {
+	i := 0
+	for i < len(s) && headers.IsToken(s[i:i+1]) {
+		i++
+	}
+	return s[:i]
+}
+
+/*
+quotedStringLength returns the length of the quoted string s starts with, RFC 9110 section 5.6.4.
+Control characters other than HTAB are not allowed in it, not even escaped.
+*/
+func quotedStringLength(s string) (int, error) {
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			return i + 1, nil
+		case c == '\\' && i+1 < len(s):
+			i++
+			c = s[i]
+		}
+		if c < ' ' && c != '\t' || c == 0x7f {
+			return 0, fmt.Errorf("invalid character in quoted chunk extension %q", s)
+		}
+	}
+	return 0, fmt.Errorf("unterminated quoted chunk extension %q", s)
+}
@@ -0,0 +1,60 @@
+package framing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseContentLength(t *testing.T) {
+	// Test: Valid lengths
+	for length, values := range map[int64][]string{
+		5:  {"5"},
+		0:  {"0"},
+		7:  {"007"},
+		10: {" 10\t"},
+		3:  {"3", "3, 3"},
+	} {
+		n, err := ParseContentLength(values)
+		require.NoError(t, err, values)
+		assert.Equal(t, length, n, values)
+	}
+
+	// Test: Invalid lengths
+	for _, values := range [][]string{
+		{""}, {"-5"}, {"+5"}, {"0x5"}, {"5e0"}, {"5 5"}, {"5,"},
+		{"99999999999999999999"}, {"5", "6"}, {"5, 6"},
+	} {
+		_, err := ParseContentLength(values)
+		require.Error(t, err, values)
+	}
+}
+
+func TestParseChunkSize(t *testing.T) {
+	// Test: Valid sizes
+	for line, size := range map[string]int64{
+		"0":                  0,
+		"a":                  10,
+		"1F":                 31,
+		"5;name=val":         5,
+		"5 ;name=val":        5,
+		"5\t":                5,
+		"5;a;b=c":            5,
+		"5; a = \"b c\" ; d": 5,
+		"5;a=\"\\\"\"":       5,
+	} {
+		n, err := ParseChunkSize([]byte(line))
+		require.NoError(t, err, line)
+		assert.Equal(t, size, n, line)
+	}
+
+	// Test: Invalid sizes
+	for _, line := range []string{
+		"", ";ext", " 5", "+5", "-5", "0x5", "zz", "ffffffffffffffff1",
+		"5;", "5;a=", "5;a\nb", "5;a=\"b", "5;a=\"b\x00\"", "5;a b", "5;a=b c", "5;a=\"b\"c", "5;a\x7f",
+	} {
+		_, err := ParseChunkSize([]byte(line))
+		require.Error(t, err, line)
+	}
+}
@@ -0,0 +1,30 @@
+package headers
+
+/*
+ParseError is an error in a message a peer sent, which is answered with StatusCode,
+such as 400 for a malformed field or 431 for too many of them.
+The sentinels below and those of the request package are ParseErrors, errors wrapping
+them carry the details, so callers match a specific one with errors.Is and get the
+status code of any with errors.As.
+*/
+type ParseError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *ParseError) Error() string {
+	return e.Message
+}
+
+var (
+	// ErrMissingColon is returned for a field line without a colon after the name.
+	ErrMissingColon = &ParseError{StatusCode: 400, Message: "error: Found no ':' in the header"}
+	// ErrWhitespaceBeforeColon is returned for whitespace between a field name and the colon, RFC 9112 section 5.1.
+	ErrWhitespaceBeforeColon = &ParseError{StatusCode: 400, Message: "error: Found whitespace between colon and key, invalid format"}
+	// ErrObsoleteLineFolding is returned for a field line starting with whitespace, RFC 9112 section 5.2.
+	ErrObsoleteLineFolding = &ParseError{StatusCode: 400, Message: "error: Field line starts with whitespace, obsolete line folding is not supported"}
+	// ErrInvalidFieldName is returned for a field name that is not a token.
+	ErrInvalidFieldName = &ParseError{StatusCode: 400, Message: "error: invalid header key"}
+	// ErrInvalidFieldValue is returned for a control character in a field value.
+	ErrInvalidFieldValue = &ParseError{StatusCode: 400, Message: "error: invalid character in header value"}
+)
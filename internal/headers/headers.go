@@ -2,18 +2,49 @@ package headers
 
 import (
 	"bytes"
-	"errors"
+	"fmt"
+	"iter"
 	"strings"
-	"unicode"
 )
 
-type Headers map[string]string
+/*
+Headers is an ordered collection of header fields.
+A name may occur several times, every occurrence keeps its own value, its position
+and the casing it was added with. Lookups ignore the casing of names.
+The zero value is an empty collection ready to use.
+*/
+type Headers struct {
+	fields []Field
+}
+
+// Field is a single header line.
+type Field struct {
+	Name  string
+	Value string
+}
 
 const crlf = "\r\n"
 
 var specialChars = []byte{'!', '#', '$', '%', '&', '*', '+', '-', '.', '^', '_', '`', '|', '~', '\''}
 
-func (h Headers) Parse(data []byte) (n int, done bool, err error) {
+/*
+IsToken reports whether s is a token as defined in RFC 9110 section 5.6.2, the syntax of
+field names and methods: one or more ASCII letters, digits or the characters in specialChars.
+*/
+func IsToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || bytes.IndexByte(specialChars, c) >= 0) {
+			return false
+		}
+	}
+	return true
+}
+
+func (h *Headers) Parse(data []byte) (n int, done bool, err error) {
 	idx := bytes.Index(data, []byte(crlf))
 	if idx == -1 {
 		return 0, false, nil
@@ -22,41 +53,176 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 	}
 	data = data[:idx]
 
+	// A line starting with whitespace continues the previous field in the obsolete
+	// line folding, a proxy taking it as such would not see the field in it
+	if data[0] == ' ' || data[0] == '\t' {
+		return 0, false, ErrObsoleteLineFolding
+	}
+
 	keyVal := bytes.SplitN(data, []byte(":"), 2)
 	if len(keyVal) != 2 {
-		return 0, false, errors.New("error: Found no ':' in the header")
+		return 0, false, ErrMissingColon
 	}
 
 	key, value := keyVal[0], keyVal[1]
-	if bytes.HasSuffix(key, []byte(" ")) {
-		return 0, false, errors.New("error: Found whitespace between colon and key, invalid format")
+	if bytes.HasSuffix(key, []byte(" ")) || bytes.HasSuffix(key, []byte("\t")) {
+		return 0, false, ErrWhitespaceBeforeColon
 	}
 
-	key = bytes.TrimSpace(key)
-	for _, char := range key {
-		if !(unicode.IsUpper(rune(char)) || unicode.IsDigit(rune(char)) || unicode.IsLower(rune(char)) || bytes.ContainsRune(specialChars, rune(char))) {
-			return 0, false, errors.New("error: invalid header key")
+	if !IsToken(string(key)) {
+		return 0, false, fmt.Errorf("%w %q", ErrInvalidFieldName, key)
+	}
+
+	// Only spaces and tabs surround a value, other control characters would let
+	// "chunked\v" pass for "chunked" here but not on a proxy in front of the server
+	value = bytes.Trim(value, " \t")
+	for _, c := range value {
+		if c < ' ' && c != '\t' || c == 0x7f {
+			return 0, false, fmt.Errorf("%w of %s", ErrInvalidFieldValue, key)
 		}
 	}
 
-	value = bytes.TrimSpace(value)
+	h.Add(string(key), string(value))
 
-	mapKey := string(bytes.ToLower(key))
+	return idx + 2, false, nil
+}
 
-	if val, exists := h[mapKey]; exists {
-		h[mapKey] = val + ", " + string(value)
-	} else {
-		h[mapKey] = string(value)
+func NewHeaders() *Headers {
+	return &Headers{}
+}
+
+/*
+Get returns the values of key combined into one comma separated value, in the order they were added.
+Use Values for fields that cannot be combined like that, such as Set-Cookie.
+*/
+func (h *Headers) Get(key string) (string, bool) {
+	values := h.Values(key)
+	if len(values) == 0 {
+		return "", false
 	}
+	return strings.Join(values, ", "), true
+}
 
-	return idx + 2, false, nil
+// Values returns every value of key in the order they were added.
+func (h *Headers) Values(key string) []string {
+	if h == nil {
+		return nil
+	}
+	var values []string
+	for _, f := range h.fields {
+		if strings.EqualFold(f.Name, key) {
+			values = append(values, f.Value)
+		}
+	}
+	return values
 }
 
-func NewHeaders() Headers {
-	return Headers{}
+// Has reports whether key occurs at least once.
+func (h *Headers) Has(key string) bool {
+	if h == nil {
+		return false
+	}
+	for _, f := range h.fields {
+		if strings.EqualFold(f.Name, key) {
+			return true
+		}
+	}
+	return false
 }
 
-func (h Headers) Get(key string) (string, bool) {
-	v, ok := h[strings.ToLower(key)]
-	return v, ok
+// HasToken reports whether the comma separated values of key contain token, ignoring case.
+func (h *Headers) HasToken(key, token string) bool {
+	for _, value := range h.Values(key) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.Trim(t, " \t"), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Add appends a field, keeping the values key already has.
+func (h *Headers) Add(key, value string) {
+	h.fields = append(h.fields, Field{Name: key, Value: value})
+}
+
+/*
+Set replaces all values of key with value. The field keeps the position of the
+first occurrence of key, or is appended if key is new.
+*/
+func (h *Headers) Set(key, value string) {
+	for i, f := range h.fields {
+		if strings.EqualFold(f.Name, key) {
+			h.fields[i] = Field{Name: key, Value: value}
+			rest := deleteFields(h.fields[i+1:], key)
+			h.fields = h.fields[:i+1+len(rest)]
+			return
+		}
+	}
+	h.Add(key, value)
+}
+
+// Del removes all values of key.
+func (h *Headers) Del(key string) {
+	h.fields = deleteFields(h.fields, key)
+}
+
+// Len returns the number of fields.
+func (h *Headers) Len() int {
+	if h == nil {
+		return 0
+	}
+	return len(h.fields)
+}
+
+// All iterates over the fields in the order they were added, with the names in their original casing.
+func (h *Headers) All() iter.Seq2[string, string] {
+	return func(yield func(string, string) bool) {
+		if h == nil {
+			return
+		}
+		for _, f := range h.fields {
+			if !yield(f.Name, f.Value) {
+				return
+			}
+		}
+	}
+}
+
+// Clone returns a copy of h that can be changed without affecting h.
+func (h *Headers) Clone() *Headers {
+	if h == nil {
+		return NewHeaders()
+	}
+	return &Headers{fields: append([]Field(nil), h.fields...)}
+}
+
+// deleteFields filters the fields named key out of fields, reusing its backing array.
+func deleteFields(fields []Field, key string) []Field {
+	kept := fields[:0]
+	for _, f := range fields {
+		if !strings.EqualFold(f.Name, key) {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+/*
+CanonicalKey returns key with the first letter and every letter following
+a hyphen in upper case and the rest in lower case, e.g. "Content-Type".
+*/
+func CanonicalKey(key string) string {
+	b := []byte(key)
+	upper := true
+	for i, c := range b {
+		if upper && 'a' <= c && c <= 'z' {
+			b[i] = c - 'a' + 'A'
+		} else if !upper && 'A' <= c && c <= 'Z' {
+			b[i] = c - 'A' + 'a'
+		}
+		upper = c == '-'
+	}
+	return string(b)
 }
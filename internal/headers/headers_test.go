@@ -14,28 +14,37 @@ func TestHeaders(t *testing.T) {
 	n, done, err := headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, "localhost:42069", get(headers, "host"))
 	assert.Equal(t, 23, n)
 	assert.False(t, done)
 
 	// Test: Valid single header with extra whitespace
 	headers = NewHeaders()
-	data = []byte("       Host: localhost:42069                           \r\n\r\n")
+	data = []byte("Host:        localhost:42069                           \r\n\r\n")
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, "localhost:42069", get(headers, "host"))
 	assert.Equal(t, 57, n)
 	assert.False(t, done)
 
+	// Test: Invalid whitespace before the name, obsolete line folding
+	for _, line := range []string{"       Host: localhost:42069\r\n", "\tHost: localhost:42069\r\n"} {
+		headers = NewHeaders()
+		n, _, err = headers.Parse([]byte(line))
+		require.ErrorIs(t, err, ErrObsoleteLineFolding, line)
+		assert.Equal(t, 0, n)
+	}
+
 	// Test: Valid 2 headers with existing headers
-	headers = map[string]string{"host": "localhost:42069"}
+	headers = NewHeaders()
+	headers.Add("Host", "localhost:42069")
 	data = []byte("User-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n")
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
-	assert.Equal(t, "curl/7.81.0", headers["user-agent"])
+	assert.Equal(t, "localhost:42069", get(headers, "host"))
+	assert.Equal(t, "curl/7.81.0", get(headers, "user-agent"))
 	assert.Equal(t, 25, n)
 	assert.False(t, done)
 
@@ -45,7 +54,7 @@ func TestHeaders(t *testing.T) {
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Empty(t, headers)
+	assert.Equal(t, 0, headers.Len())
 	assert.Equal(t, 2, n)
 	assert.True(t, done)
 
@@ -57,7 +66,6 @@ func TestHeaders(t *testing.T) {
 	assert.Equal(t, 0, n)
 	assert.False(t, done)
 
-
 	// Test: Invalid special header
 	headers = NewHeaders()
 	data = []byte("H©st: localhost:42069\r\n\r\n\r\n\r\n")
@@ -66,11 +74,97 @@ func TestHeaders(t *testing.T) {
 	assert.Equal(t, 0, n)
 	assert.False(t, done)
 
+	// Test: Non-ASCII letter and empty name
+	for _, line := range []string{"H\xc0st: x\r\n", ": x\r\n"} {
+		headers = NewHeaders()
+		_, _, err = headers.Parse([]byte(line))
+		require.Error(t, err, line)
+	}
+
+	// Test: Errors match their sentinel and carry a status code
+	for line, sentinel := range map[string]error{
+		"Host localhost\r\n":      ErrMissingColon,
+		"Host : localhost\r\n":    ErrWhitespaceBeforeColon,
+		"H(st: localhost\r\n":     ErrInvalidFieldName,
+		"Host: local\x00host\r\n": ErrInvalidFieldValue,
+	} {
+		_, _, err = NewHeaders().Parse([]byte(line))
+		require.ErrorIs(t, err, sentinel, line)
+		var parseErr *ParseError
+		require.ErrorAs(t, err, &parseErr)
+		assert.Equal(t, 400, parseErr.StatusCode)
+	}
+
 	// Test: Same header with multiple values
-	headers = Headers{"set-person" : "lane-loves-go"}
+	headers = NewHeaders()
+	headers.Add("Set-Person", "lane-loves-go")
 	data = []byte("Set-Person: prime-loves-zig\r\n\r\n")
 	_, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	assert.False(t, done)
-	assert.Equal(t,"lane-loves-go, prime-loves-zig", headers["set-person"])
+	assert.Equal(t, "lane-loves-go, prime-loves-zig", get(headers, "set-person"))
+}
+
+func TestHeadersCollection(t *testing.T) {
+	// Test: Values keep their order and are not joined
+	headers := NewHeaders()
+	data := []byte("Set-Cookie: a=1; Path=/\r\nContent-Type: text/plain\r\nset-cookie: b=2\r\n\r\n")
+	total := 0
+	for {
+		n, done, err := headers.Parse(data[total:])
+		require.NoError(t, err)
+		total += n
+		if done {
+			break
+		}
+	}
+	assert.Equal(t, []string{"a=1; Path=/", "b=2"}, headers.Values("SET-COOKIE"))
+
+	// Test: Fields keep insertion order and original casing
+	var names []string
+	for name := range headers.All() {
+		names = append(names, name)
+	}
+	assert.Equal(t, []string{"Set-Cookie", "Content-Type", "set-cookie"}, names)
+
+	// Test: Tokens in comma separated values, across fields and ignoring case
+	h := NewHeaders()
+	h.Add("Connection", "Upgrade,\tKeep-Alive")
+	h.Add("connection", "close")
+	assert.True(t, h.HasToken("Connection", "keep-alive"))
+	assert.True(t, h.HasToken("CONNECTION", "Close"))
+	assert.False(t, h.HasToken("Connection", "keep"))
+	assert.False(t, h.HasToken("Upgrade", "upgrade"))
+
+	// Test: Set replaces every value in place of the first one
+	headers.Set("Set-Cookie", "c=3")
+	names = nil
+	for name, value := range headers.All() {
+		names = append(names, name+"="+value)
+	}
+	assert.Equal(t, []string{"Set-Cookie=c=3", "Content-Type=text/plain"}, names)
+
+	// Test: Add appends and Del removes all values
+	headers.Add("X-Trace", "1")
+	headers.Add("x-trace", "2")
+	assert.Equal(t, "1, 2", get(headers, "X-Trace"))
+	headers.Del("X-TRACE")
+	assert.False(t, headers.Has("x-trace"))
+	assert.Equal(t, 2, headers.Len())
+
+	// Test: Nil headers read as empty
+	var missing *Headers
+	assert.False(t, missing.Has("Host"))
+	assert.Equal(t, 0, missing.Len())
+}
+
+func TestCanonicalKey(t *testing.T) {
+	assert.Equal(t, "Content-Type", CanonicalKey("content-type"))
+	assert.Equal(t, "X-Request-Id", CanonicalKey("X-REQUEST-ID"))
+	assert.Equal(t, "Www-Authenticate", CanonicalKey("WWW-Authenticate"))
+}
+
+func get(h *Headers, key string) string {
+	value, _ := h.Get(key)
+	return value
 }
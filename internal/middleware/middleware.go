@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"time"
+
+	"github.com/TheBarnakhil/httpfromtcp/internal/request"
+	"github.com/TheBarnakhil/httpfromtcp/internal/response"
+	"github.com/TheBarnakhil/httpfromtcp/internal/server"
+)
+
+// RequestIDHeader carries the ID that RequestID assigns to every request.
+const RequestIDHeader = "X-Request-Id"
+
+// Logging logs every request with the status code, body size and duration of its response.
+func Logging(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		start := time.Now()
+		next(w, req)
+		id, _ := req.Headers.Get(RequestIDHeader)
+		log.Printf("%s %s %d %d bytes %v %s",
+			req.RequestLine.Method, req.RequestLine.RequestTarget,
+			w.StatusCode(), w.BytesWritten(), time.Since(start), id)
+	}
+}
+
+/*
+Recover turns a panic in the handler into a logged stack trace and,
+if none of the handler's response was sent yet, a 500 in its place.
+*/
+func Recover(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				log.Printf("Panic serving %s %s: %v\n%s", req.RequestLine.Method, req.RequestLine.RequestTarget, err, debug.Stack())
+				if w.Reset() {
+					body := []byte(fmt.Sprintf("%d %s\n", response.ServerError, response.StatusText(response.ServerError)))
+					h := response.GetDefaultHeaders(len(body), response.Plain)
+					w.WriteStatusLine(response.ServerError)
+					w.WriteHeaders(h)
+					w.WriteBody(body)
+				}
+			}
+		}()
+		next(w, req)
+	}
+}
+
+/*
+RequestID makes sure every request carries an X-Request-Id header, generating one
+unless the client sent it, and echoes it in the response.
+*/
+func RequestID(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		id, ok := req.Headers.Get(RequestIDHeader)
+		if !ok || id == "" {
+			id = newRequestID()
+			req.Headers.Set(RequestIDHeader, id)
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next(w, req)
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,121 @@
+package middleware
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/TheBarnakhil/httpfromtcp/internal/request"
+	"github.com/TheBarnakhil/httpfromtcp/internal/response"
+	"github.com/TheBarnakhil/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChain(t *testing.T) {
+	var order []string
+	trace := func(name string) server.Middleware {
+		return func(next server.Handler) server.Handler {
+			return func(w *response.Writer, req *request.Request) {
+				order = append(order, name+" in")
+				next(w, req)
+				order = append(order, name+" out")
+			}
+		}
+	}
+	handler := server.Chain(okHandler, trace("a"), trace("b"))
+	serve(t, handler, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, []string{"a in", "b in", "b out", "a out"}, order)
+}
+
+func TestObserveResponse(t *testing.T) {
+	var statusCode response.StatusCode
+	var written int64
+	observe := func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			next(w, req)
+			statusCode, written = w.StatusCode(), w.BytesWritten()
+		}
+	}
+	serve(t, server.Chain(okHandler, Logging, observe), "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, response.OK, statusCode)
+	assert.Equal(t, int64(2), written)
+}
+
+func TestLogging(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	}()
+
+	// Test: Status of a response left for the server to commit
+	serve(t, Logging(func(w *response.Writer, req *request.Request) {
+		w.WriteHeader(response.NoContent)
+	}), "DELETE /x HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(logged.String(), "DELETE /x 204 0 bytes "), logged.String())
+
+	// Test: Handler that only set headers gets a 200
+	logged.Reset()
+	serve(t, Logging(func(w *response.Writer, req *request.Request) {
+		w.Header().Set("X-Done", "yes")
+	}), "GET /y HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(logged.String(), "GET /y 200 0 bytes "), logged.String())
+}
+
+func TestRecover(t *testing.T) {
+	// Test: Panic before the response started
+	resp := serve(t, Recover(func(w *response.Writer, req *request.Request) {
+		panic("boom")
+	}), "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 500 Internal Server Error\r\n"))
+
+	// Test: Panic after a body write that was still held back
+	resp = serve(t, Recover(func(w *response.Writer, req *request.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"partial":`))
+		panic("boom")
+	}), "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 500 Internal Server Error\r\n"))
+	assert.NotContains(t, resp, "partial")
+
+	// Test: Panic after the status line was sent
+	resp = serve(t, Recover(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.OK)
+		panic("boom")
+	}), "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", resp)
+}
+
+func TestRequestID(t *testing.T) {
+	// Test: Generated ID is visible to the handler and echoed
+	var seen string
+	resp := serve(t, RequestID(func(w *response.Writer, req *request.Request) {
+		seen, _ = req.Headers.Get(RequestIDHeader)
+		okHandler(w, req)
+	}), "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Len(t, seen, 32)
+	assert.Contains(t, resp, "X-Request-Id: "+seen+"\r\n")
+
+	// Test: ID sent by the client is kept
+	resp = serve(t, RequestID(okHandler), "GET / HTTP/1.1\r\nHost: localhost\r\nX-Request-Id: abc\r\n\r\n")
+	assert.Contains(t, resp, "X-Request-Id: abc\r\n")
+}
+
+func okHandler(w *response.Writer, _ *request.Request) {
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(response.GetDefaultHeaders(2, response.Plain))
+	w.WriteBody([]byte("ok"))
+}
+
+func serve(t *testing.T, handler server.Handler, raw string) string {
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	handler(response.NewWriter(&buf), req)
+	return buf.String()
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"time"
+
+	"github.com/TheBarnakhil/httpfromtcp/internal/client"
+)
+
+// Option configures a ReverseProxy when it is created.
+type Option func(*ReverseProxy)
+
+// WithClient sets the client that sends the requests to the upstream.
+func WithClient(c *client.Client) Option {
+	return func(p *ReverseProxy) {
+		p.Client = c
+	}
+}
+
+// WithStripPrefix removes prefix from request paths before they are appended to the upstream path.
+func WithStripPrefix(prefix string) Option {
+	return func(p *ReverseProxy) {
+		p.StripPrefix = prefix
+	}
+}
+
+// WithContentDigest sends the SHA-256 and the length of each relayed body in the X-Content-SHA256 and X-Content-Length trailers.
+func WithContentDigest() Option {
+	return func(p *ReverseProxy) {
+		p.ContentDigest = true
+	}
+}
+
+// PoolOption configures a Pool when it is created.
+type PoolOption func(*Pool)
+
+// WithStrategy sets how backends are picked, round-robin by default.
+func WithStrategy(s Strategy) PoolOption {
+	return func(p *Pool) {
+		p.Strategy = s
+	}
+}
+
+// WithHealthCheck requests path from every backend each interval and stops using those that fail until they pass again.
+func WithHealthCheck(path string, interval time.Duration) PoolOption {
+	return func(p *Pool) {
+		p.HealthCheckPath = path
+		p.HealthCheckInterval = interval
+	}
+}
+
+// WithHealthCheckClient sets the client that sends the health checks.
+func WithHealthCheckClient(c *client.Client) PoolOption {
+	return func(p *Pool) {
+		p.HealthCheckClient = c
+	}
+}
+
+// WithEjection stops using a backend for duration once maxFailures requests in a row got no response from it, 0 disables it.
+func WithEjection(maxFailures int, duration time.Duration) PoolOption {
+	return func(p *Pool) {
+		p.MaxFailures = maxFailures
+		p.EjectionTime = duration
+	}
+}
+
+// WithRetries sets on how many other backends an idempotent request is retried.
+func WithRetries(n int) PoolOption {
+	return func(p *Pool) {
+		p.Retries = n
+	}
+}
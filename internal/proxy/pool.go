@@ -0,0 +1,193 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/TheBarnakhil/httpfromtcp/internal/client"
+	"github.com/TheBarnakhil/httpfromtcp/internal/request"
+)
+
+/*
+Pool is a set of interchangeable upstream servers a ReverseProxy balances requests across.
+
+The Strategy picks the backend for each request among those that are available. A backend
+is unavailable while it fails its active health checks, when HealthCheckPath is set, and
+for EjectionTime after MaxFailures requests in a row could not get a response from it.
+Idempotent requests that could not get a response are retried on up to Retries other backends.
+*/
+type Pool struct {
+	Backends []*Backend
+	Strategy Strategy
+
+	// HealthCheckPath is requested from every backend each HealthCheckInterval, empty disables health checks
+	HealthCheckPath     string
+	HealthCheckInterval time.Duration
+	// HealthCheckClient sends the health checks, its timeout decides how long a backend has to answer
+	HealthCheckClient *client.Client
+
+	// MaxFailures is how many failed requests in a row eject a backend, 0 never ejects, nor does a pool of one
+	MaxFailures  int
+	EjectionTime time.Duration
+	Retries      int
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// Backend is one upstream server of a Pool.
+type Backend struct {
+	URL *url.URL
+
+	// active is the number of requests in flight
+	active atomic.Int64
+	// unhealthy is set while the backend fails its health checks
+	unhealthy atomic.Bool
+	failures  atomic.Int32
+	// ejectedUntil is when passive ejection ends, in nanoseconds since the Unix epoch
+	ejectedUntil atomic.Int64
+}
+
+// NewPool returns a Pool of upstreams, absolute http or https URLs, and starts its health checks if it has any.
+func NewPool(upstreams []string, opts ...PoolOption) (*Pool, error) {
+	if len(upstreams) == 0 {
+		return nil, fmt.Errorf("error: A pool needs at least one upstream")
+	}
+	p := &Pool{
+		Strategy:            RoundRobin(),
+		HealthCheckInterval: 10 * time.Second,
+		MaxFailures:         3,
+		EjectionTime:        30 * time.Second,
+		Retries:             2,
+	}
+	for _, upstream := range upstreams {
+		u, err := parseUpstream(upstream)
+		if err != nil {
+			return nil, err
+		}
+		p.Backends = append(p.Backends, &Backend{URL: u})
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.HealthCheckClient == nil {
+		p.HealthCheckClient = client.New(client.WithTimeout(p.HealthCheckInterval))
+	}
+
+	p.stop = make(chan struct{})
+	p.done = make(chan struct{})
+	if p.HealthCheckPath == "" {
+		close(p.done)
+	} else {
+		go p.healthCheckLoop()
+	}
+	return p, nil
+}
+
+// parseUpstream checks that upstream is an absolute http or https URL.
+func parseUpstream(upstream string) (*url.URL, error) {
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return nil, fmt.Errorf("error: Invalid upstream URL %q: %w", upstream, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("error: Upstream URL %q is not an absolute http or https URL", upstream)
+	}
+	return u, nil
+}
+
+// Close stops the health checks.
+func (p *Pool) Close() {
+	p.once.Do(func() {
+		close(p.stop)
+	})
+	<-p.done
+}
+
+// Available reports whether b passes its health checks and is not ejected.
+func (b *Backend) Available() bool {
+	return !b.unhealthy.Load() && time.Now().UnixNano() >= b.ejectedUntil.Load()
+}
+
+// Active returns the number of requests b is serving.
+func (b *Backend) Active() int64 {
+	return b.active.Load()
+}
+
+// pick returns the backend for req, leaving out those in tried, or nil if none is available.
+func (p *Pool) pick(req *request.Request, tried []*Backend) *Backend {
+	return p.Strategy.Pick(req, p.Backends, func(b *Backend) bool {
+		return b.Available() && !slices.Contains(tried, b)
+	})
+}
+
+// failed counts a request b gave no response to, and ejects b once there were MaxFailures in a row.
+func (p *Pool) failed(b *Backend) {
+	// a lone backend is never ejected, there would be nothing left to send requests to
+	if p.MaxFailures <= 0 || len(p.Backends) == 1 || int(b.failures.Add(1)) < p.MaxFailures {
+		return
+	}
+	b.failures.Store(0)
+	b.ejectedUntil.Store(time.Now().Add(p.EjectionTime).UnixNano())
+	log.Printf("Ejecting backend %s for %v after %d failures", b.URL.Host, p.EjectionTime, p.MaxFailures)
+}
+
+// succeeded resets the failures of b.
+func (p *Pool) succeeded(b *Backend) {
+	b.failures.Store(0)
+}
+
+func (p *Pool) healthCheckLoop() {
+	defer close(p.done)
+	ticker := time.NewTicker(p.HealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		p.checkHealth()
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkHealth checks all backends at once and marks those that fail unhealthy until they pass again.
+func (p *Pool) checkHealth() {
+	var wg sync.WaitGroup
+	for _, b := range p.Backends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := p.check(b)
+			if b.unhealthy.Swap(err != nil) == (err == nil) {
+				if err == nil {
+					log.Printf("Backend %s is healthy again", b.URL.Host)
+				} else {
+					log.Printf("Backend %s failed its health check: %v", b.URL.Host, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// check requests HealthCheckPath from b, which has to answer with a 2xx or 3xx.
+func (p *Pool) check(b *Backend) error {
+	resp, err := p.HealthCheckClient.Get(b.URL.JoinPath(p.HealthCheckPath).String())
+	if err != nil {
+		return err
+	}
+	if _, err := resp.ReadBody(); err != nil {
+		return err
+	}
+	if code := resp.StatusLine.StatusCode; code < 200 || code >= 400 {
+		return fmt.Errorf("error: Status %d", code)
+	}
+	return nil
+}
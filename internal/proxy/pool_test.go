@@ -0,0 +1,179 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/TheBarnakhil/httpfromtcp/internal/client"
+	"github.com/TheBarnakhil/httpfromtcp/internal/headers"
+	"github.com/TheBarnakhil/httpfromtcp/internal/request"
+	"github.com/TheBarnakhil/httpfromtcp/internal/response"
+	"github.com/TheBarnakhil/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStrategies(t *testing.T) {
+	var backends []*Backend
+	for _, host := range []string{"a", "b", "c"} {
+		backends = append(backends, &Backend{URL: &url.URL{Scheme: "http", Host: host}})
+	}
+	a, b, c := backends[0], backends[1], backends[2]
+	all := func(*Backend) bool { return true }
+	except := func(skip *Backend) func(*Backend) bool {
+		return func(b *Backend) bool { return b != skip }
+	}
+	withKey := func(key string) *request.Request {
+		req := &request.Request{Headers: headers.NewHeaders()}
+		req.Headers.Set("X-User", key)
+		return req
+	}
+	plain := &request.Request{Headers: headers.NewHeaders()}
+
+	// Test: Round-robin takes turns and skips unavailable backends
+	rr := RoundRobin()
+	var picked []string
+	for range 4 {
+		picked = append(picked, rr.Pick(plain, backends, all).URL.Host)
+	}
+	assert.Equal(t, []string{"a", "b", "c", "a"}, picked)
+	assert.Equal(t, c, rr.Pick(plain, backends, except(b)))
+	assert.Nil(t, rr.Pick(plain, backends, func(*Backend) bool { return false }))
+
+	// Test: Least connections picks the backend with the fewest requests in flight
+	a.active.Store(2)
+	b.active.Store(1)
+	c.active.Store(3)
+	lc := LeastConnections()
+	assert.Equal(t, b, lc.Pick(plain, backends, all))
+	assert.Equal(t, a, lc.Pick(plain, backends, except(b)))
+	a.active.Store(0)
+	b.active.Store(0)
+	c.active.Store(0)
+
+	// Test: Consistent hashing keeps keys on their backend and only moves those of an unavailable one
+	ch := ConsistentHash("X-User")
+	owners := map[string]*Backend{}
+	counts := map[*Backend]int{}
+	for i := range 300 {
+		key := fmt.Sprintf("user-%d", i)
+		owners[key] = ch.Pick(withKey(key), backends, all)
+		counts[owners[key]]++
+		assert.Equal(t, owners[key], ch.Pick(withKey(key), backends, all))
+	}
+	for _, backend := range backends {
+		assert.Greater(t, counts[backend], 50, backend.URL.Host)
+	}
+	for key, owner := range owners {
+		picked := ch.Pick(withKey(key), backends, except(b))
+		if owner == b {
+			assert.NotEqual(t, b, picked)
+		} else {
+			assert.Equal(t, owner, picked)
+		}
+	}
+
+	// Test: Consistent hashing without the header takes turns
+	assert.Equal(t, a, ch.Pick(plain, backends, all))
+	assert.Equal(t, b, ch.Pick(plain, backends, all))
+}
+
+func TestPool(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+	newBackend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/health" && !healthy.Load() && name == "a" {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			io.WriteString(w, name)
+		}))
+	}
+	a, c := newBackend("a"), newBackend("c")
+	defer a.Close()
+	defer c.Close()
+	dead := "http://" + deadAddr(t)
+
+	get := func(p *ReverseProxy, method string, body io.Reader) (response.StatusCode, string) {
+		srv, err := server.Serve(0, p.Handler())
+		require.NoError(t, err)
+		defer srv.Close()
+		req, err := client.NewRequest(method, fmt.Sprintf("http://127.0.0.1:%d/", srv.Listener.Addr().(*net.TCPAddr).Port), body)
+		require.NoError(t, err)
+		resp, err := client.New().Do(req)
+		require.NoError(t, err)
+		b, err := resp.ReadBody()
+		require.NoError(t, err)
+		return resp.StatusLine.StatusCode, string(b)
+	}
+
+	// Test: Idempotent requests are retried on another backend and a failing backend is ejected
+	pool, err := NewPool([]string{a.URL, dead, c.URL}, WithEjection(2, time.Minute))
+	require.NoError(t, err)
+	defer pool.Close()
+	p := NewBalancer(pool)
+	var bodies []string
+	for range 5 {
+		status, body := get(p, "GET", nil)
+		assert.Equal(t, response.OK, status)
+		bodies = append(bodies, body)
+	}
+	assert.Equal(t, []string{"a", "c", "a", "c", "a"}, bodies)
+	assert.True(t, pool.Backends[0].Available())
+	assert.False(t, pool.Backends[1].Available())
+	assert.Zero(t, pool.Backends[0].Active())
+
+	// Test: Requests that are not idempotent are not retried
+	pool, err = NewPool([]string{dead, a.URL})
+	require.NoError(t, err)
+	defer pool.Close()
+	p = NewBalancer(pool)
+	status, _ := get(p, "POST", strings.NewReader("once"))
+	assert.Equal(t, response.BadGateway, status)
+	_, body := get(p, "POST", strings.NewReader("once"))
+	assert.Equal(t, "a", body)
+
+	// Test: Backends failing their health check get no requests until they pass again
+	pool, err = NewPool([]string{a.URL, c.URL}, WithHealthCheck("/health", 10*time.Millisecond))
+	require.NoError(t, err)
+	defer pool.Close()
+	p = NewBalancer(pool)
+	healthy.Store(false)
+	require.Eventually(t, func() bool { return !pool.Backends[0].Available() }, time.Second, 5*time.Millisecond)
+	for range 3 {
+		_, body := get(p, "GET", nil)
+		assert.Equal(t, "c", body)
+	}
+	healthy.Store(true)
+	require.Eventually(t, func() bool { return pool.Backends[0].Available() }, time.Second, 5*time.Millisecond)
+
+	// Test: No backend available
+	pool, err = NewPool([]string{a.URL}, WithHealthCheck("/health", 10*time.Millisecond))
+	require.NoError(t, err)
+	defer pool.Close()
+	healthy.Store(false)
+	require.Eventually(t, func() bool { return !pool.Backends[0].Available() }, time.Second, 5*time.Millisecond)
+	status, _ = get(NewBalancer(pool), "GET", nil)
+	assert.Equal(t, response.ServiceUnavailable, status)
+
+	_, err = NewPool(nil)
+	require.Error(t, err)
+}
+
+// deadAddr returns an address nothing listens on.
+func deadAddr(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+	return addr
+}
@@ -0,0 +1,372 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/TheBarnakhil/httpfromtcp/internal/client"
+	"github.com/TheBarnakhil/httpfromtcp/internal/headers"
+	"github.com/TheBarnakhil/httpfromtcp/internal/request"
+	"github.com/TheBarnakhil/httpfromtcp/internal/response"
+	"github.com/TheBarnakhil/httpfromtcp/internal/server"
+)
+
+/*
+ReverseProxy forwards requests to an upstream server and relays its responses.
+
+The method, the path and query below the upstream URL, the headers and the body of a
+request are forwarded, and the status, headers, body and trailers of the response are
+passed back. Bodies are streamed in both directions. Hop-by-hop headers, which only
+concern a single connection, are dropped on the way, and the upstream learns about the
+client from the X-Forwarded-For, X-Forwarded-Host, X-Forwarded-Proto and Forwarded headers.
+
+The upstream is picked from Pool for every request. An upstream that cannot be reached is
+answered with a 502, one that does not answer in time with a 504, and a 503 is sent when
+no upstream of the pool is available.
+*/
+type ReverseProxy struct {
+	Pool   *Pool
+	Client *client.Client
+	// StripPrefix is removed from the request path before it is appended to the upstream path
+	StripPrefix string
+	// ContentDigest makes the proxy send the SHA-256 and the length of each relayed body as trailers
+	ContentDigest bool
+}
+
+// hopHeaders are the hop-by-hop headers of RFC 9110 section 7.6.1, along with their obsolete variants.
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"TE",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// errNoBackend is returned when every backend of the pool is unavailable or was tried already.
+var errNoBackend = errors.New("error: No backend available")
+
+// New returns a ReverseProxy for upstream, an absolute http or https URL.
+func New(upstream string, opts ...Option) (*ReverseProxy, error) {
+	pool, err := NewPool([]string{upstream})
+	if err != nil {
+		return nil, err
+	}
+	return NewBalancer(pool, opts...), nil
+}
+
+// NewBalancer returns a ReverseProxy spreading requests across the backends of pool.
+func NewBalancer(pool *Pool, opts ...Option) *ReverseProxy {
+	p := &ReverseProxy{Pool: pool, Client: client.New()}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Handler returns the server.Handler forwarding requests to the upstream.
+func (p *ReverseProxy) Handler() server.Handler {
+	return p.serve
+}
+
+func (p *ReverseProxy) serve(w *response.Writer, req *request.Request) {
+	resp, backend, err := p.roundTrip(req)
+	if err != nil {
+		log.Printf("Error forwarding %s %s: %v", req.RequestLine.Method, req.RequestLine.RequestTarget, err)
+		switch {
+		case errors.Is(err, errBadTarget):
+			writeError(w, response.BadRequest)
+		case errors.Is(err, errNoBackend):
+			writeError(w, response.ServiceUnavailable)
+		case errors.Is(err, os.ErrDeadlineExceeded):
+			writeError(w, response.GatewayTimeout)
+		default:
+			writeError(w, response.BadGateway)
+		}
+		return
+	}
+	defer backend.active.Add(-1)
+	defer resp.BodyReader.Close()
+
+	h := resp.Headers.Clone()
+	te, _ := h.Get("Transfer-Encoding")
+	chunked := strings.Contains(strings.ToLower(te), "chunked")
+	removeHopHeaders(h)
+	if chunked {
+		// keep relaying chunks, they may be spread out in time and be followed by trailers
+		h.Del("Content-Length")
+		h.Set("Transfer-Encoding", "chunked")
+	}
+	var digest *contentDigest
+	body := io.Reader(resp.BodyReader)
+	if p.ContentDigest && req.RequestLine.Method != "HEAD" && bodyAllowed(resp.StatusLine.StatusCode) {
+		// the digest is only known once the whole body went through, so it can only follow as trailers
+		digest = &contentDigest{sha: sha256.New()}
+		body = io.TeeReader(body, digest)
+		chunked = true
+		h.Del("Content-Length")
+		h.Set("Transfer-Encoding", "chunked")
+		h.Add("Trailer", "X-Content-SHA256, X-Content-Length")
+	}
+	if err := w.WriteStatusLineWithReason(resp.StatusLine.StatusCode, resp.StatusLine.ReasonPhrase); err != nil {
+		writeError(w, response.BadGateway)
+		return
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		w.Abort()
+		return
+	}
+
+	if err := copyBody(w, body); err != nil {
+		log.Printf("Error relaying the response to %s %s: %v", req.RequestLine.Method, req.RequestLine.RequestTarget, err)
+		w.Abort()
+		return
+	}
+	trailers := resp.Trailers
+	if digest != nil {
+		trailers = trailers.Clone()
+		trailers.Set("X-Content-SHA256", fmt.Sprintf("%x", digest.sha.Sum(nil)))
+		trailers.Set("X-Content-Length", strconv.FormatInt(digest.n, 10))
+	}
+	if chunked && trailers.Len() != 0 {
+		if _, err := w.WriteChunkedBodyDone(); err == nil {
+			w.WriteTrailers(trailers)
+		}
+	}
+}
+
+// contentDigest hashes and counts the bytes of a body written to it.
+type contentDigest struct {
+	sha interface {
+		io.Writer
+		Sum(b []byte) []byte
+	}
+	n int64
+}
+
+func (d *contentDigest) Write(p []byte) (int, error) {
+	d.n += int64(len(p))
+	return d.sha.Write(p)
+}
+
+// bodyAllowed reports whether a response with statusCode has a body, RFC 9110 sections 15.3.5 and 15.4.5.
+func bodyAllowed(statusCode response.StatusCode) bool {
+	return statusCode >= 200 && statusCode != response.NoContent && statusCode != response.NotModified
+}
+
+/*
+roundTrip sends req to a backend of the pool and returns its response along with the
+backend, whose count of active requests the caller decrements once it is done with the
+response. A request that got no response is retried on another backend if it is
+idempotent and its body, if any, can be sent again.
+*/
+func (p *ReverseProxy) roundTrip(req *request.Request) (*response.Response, *Backend, error) {
+	var tried []*Backend
+	var lastErr error
+	for {
+		backend := p.Pool.pick(req, tried)
+		if backend == nil {
+			if lastErr != nil {
+				return nil, nil, lastErr
+			}
+			return nil, nil, errNoBackend
+		}
+		if lastErr != nil {
+			log.Printf("Retrying %s %s on %s after: %v", req.RequestLine.Method, req.RequestLine.RequestTarget, backend.URL.Host, lastErr)
+		}
+		out, err := p.outgoing(req, backend.URL)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		backend.active.Add(1)
+		resp, err := p.Client.Do(out)
+		if err == nil {
+			p.Pool.succeeded(backend)
+			return resp, backend, nil
+		}
+		backend.active.Add(-1)
+		p.Pool.failed(backend)
+		lastErr = fmt.Errorf("%s: %w", backend.URL.Host, err)
+
+		tried = append(tried, backend)
+		replayable := out.Body == nil || req.Body != nil
+		if len(tried) > p.Pool.Retries || !req.IsIdempotent() || !replayable {
+			return nil, nil, lastErr
+		}
+	}
+}
+
+// errBadTarget is returned for request targets that cannot be forwarded, those without a path.
+var errBadTarget = errors.New("error: Invalid request target")
+
+// outgoing builds the request forwarded to upstream for req.
+func (p *ReverseProxy) outgoing(req *request.Request, upstream *url.URL) (*client.Request, error) {
+	if req.Target.Form != request.OriginForm && req.Target.Form != request.AbsoluteForm {
+		return nil, fmt.Errorf("%w: %q", errBadTarget, req.RequestLine.RequestTarget)
+	}
+	path := strings.TrimPrefix(req.Target.RawPath, p.StripPrefix)
+	query := req.Target.RawQuery
+
+	u := *upstream
+	u.RawPath = joinPath(upstream.EscapedPath(), path)
+	var err error
+	if u.Path, err = url.PathUnescape(u.RawPath); err != nil {
+		return nil, fmt.Errorf("%w: %v", errBadTarget, err)
+	}
+	if u.RawQuery == "" || query == "" {
+		u.RawQuery += query
+	} else {
+		u.RawQuery += "&" + query
+	}
+
+	h := req.Headers.Clone()
+	removeHopHeaders(h)
+	// the client sets the framing and the upstream's Host
+	h.Del("Content-Length")
+	h.Del("Host")
+	addForwarded(h, req)
+
+	out := &client.Request{
+		Method:   req.RequestLine.Method,
+		URL:      &u,
+		Headers:  h,
+		Trailers: req.Trailers,
+	}
+	te, _ := req.Headers.Get("Transfer-Encoding")
+	cl, _ := req.Headers.Get("Content-Length")
+	n, _ := strconv.ParseInt(cl, 10, 64)
+	switch {
+	case req.Body != nil:
+		// the body was read already
+		out.Body = bytes.NewReader(req.Body)
+		out.ContentLength = int64(len(req.Body))
+	case strings.Contains(strings.ToLower(te), "chunked"):
+		out.Body = req.BodyReader
+		out.ContentLength = -1
+	case n > 0:
+		out.Body = req.BodyReader
+		out.ContentLength = n
+	}
+	return out, nil
+}
+
+// addForwarded tells the upstream who the client is and which host and scheme it asked for.
+func addForwarded(h *headers.Headers, req *request.Request) {
+	host, _ := req.Headers.Get("Host")
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		ip = req.RemoteAddr
+	}
+
+	if ip != "" {
+		if prior, ok := h.Get("X-Forwarded-For"); ok {
+			h.Set("X-Forwarded-For", prior+", "+ip)
+		} else {
+			h.Set("X-Forwarded-For", ip)
+		}
+	}
+	if host != "" {
+		h.Set("X-Forwarded-Host", host)
+	}
+	h.Set("X-Forwarded-Proto", proto)
+
+	var forwarded []string
+	if ip != "" {
+		node := ip
+		if strings.Contains(ip, ":") {
+			// IPv6 addresses are quoted and bracketed
+			node = `"[` + ip + `]"`
+		}
+		forwarded = append(forwarded, "for="+node)
+	}
+	if host != "" {
+		forwarded = append(forwarded, "host="+quote(host))
+	}
+	forwarded = append(forwarded, "proto="+proto)
+	h.Add("Forwarded", strings.Join(forwarded, ";"))
+}
+
+// quote returns value as a quoted string if it is not a token.
+func quote(value string) string {
+	if strings.ContainsAny(value, ":[]\" ;,") {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+// removeHopHeaders drops the hop-by-hop headers from h, including those listed in its Connection header.
+func removeHopHeaders(h *headers.Headers) {
+	for _, value := range h.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+// joinPath appends path to base with exactly one slash between them.
+func joinPath(base, path string) string {
+	switch {
+	case base == "":
+		return path
+	case strings.HasSuffix(base, "/") && strings.HasPrefix(path, "/"):
+		return base + path[1:]
+	case !strings.HasSuffix(base, "/") && !strings.HasPrefix(path, "/"):
+		return base + "/" + path
+	}
+	return base + path
+}
+
+// copyBody relays body to w as it arrives, flushing after every read so nothing waits for the rest.
+func copyBody(w *response.Writer, body io.Reader) error {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			if werr := w.Flush(); werr != nil {
+				return werr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// writeError answers with statusCode and its reason phrase as a plain text body, unless the response has been started.
+func writeError(w *response.Writer, statusCode response.StatusCode) {
+	if !w.Reset() {
+		w.Abort()
+		return
+	}
+	body := []byte(fmt.Sprintf("%d %s\n", statusCode, response.StatusText(statusCode)))
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body), response.Plain))
+	w.WriteBody(body)
+}
@@ -0,0 +1,206 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TheBarnakhil/httpfromtcp/internal/client"
+	"github.com/TheBarnakhil/httpfromtcp/internal/response"
+	"github.com/TheBarnakhil/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReverseProxy(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/base/echo":
+			body, _ := io.ReadAll(r.Body)
+			for _, key := range []string{"X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto", "Forwarded", "X-Custom", "X-Hop", "Keep-Alive"} {
+				w.Header().Set("Echo-"+key, r.Header.Get(key))
+			}
+			w.Header().Set("Echo-Method", r.Method)
+			w.Header().Set("Echo-Uri", r.RequestURI)
+			w.Header().Set("Echo-Host", r.Host)
+			w.Header().Set("Echo-Trailer", r.Trailer.Get("X-Checksum"))
+			w.Header().Set("Connection", "X-Secret")
+			w.Header().Set("X-Secret", "hop")
+			w.Write(body)
+		case "/base/teapot":
+			w.WriteHeader(http.StatusTeapot)
+			io.WriteString(w, "short and stout")
+		case "/base/stream":
+			w.Header().Set("Trailer", "X-Done")
+			io.WriteString(w, "first")
+			w.(http.Flusher).Flush()
+			<-release
+			io.WriteString(w, "second")
+			w.Header().Set("X-Done", "yes")
+		case "/base/slow":
+			time.Sleep(200 * time.Millisecond)
+		}
+	}))
+	defer upstream.Close()
+
+	p, err := New(upstream.URL+"/base", WithStripPrefix("/api"),
+		WithClient(client.New(client.WithTimeout(100*time.Millisecond))))
+	require.NoError(t, err)
+	srv, err := server.Serve(0, p.Handler())
+	require.NoError(t, err)
+	defer srv.Close()
+	addr := fmt.Sprintf("127.0.0.1:%d", srv.Listener.Addr().(*net.TCPAddr).Port)
+	base := "http://" + addr + "/api"
+	c := client.New()
+	defer c.CloseIdleConnections()
+
+	// Test: Method, path, query, headers and a chunked body with trailers are forwarded
+	req, err := client.NewRequest("PATCH", base+"/echo?x=1&y=%20", io.MultiReader(strings.NewReader("hello")))
+	require.NoError(t, err)
+	req.Headers.Set("X-Custom", "kept")
+	req.Headers.Set("Connection", "X-Hop")
+	req.Headers.Set("X-Hop", "dropped")
+	req.Headers.Set("Keep-Alive", "timeout=5")
+	req.Headers.Set("X-Forwarded-For", "10.0.0.1")
+	req.Headers.Set("Trailer", "X-Checksum")
+	req.Trailers.Set("X-Checksum", "abc")
+	resp, err := c.Do(req)
+	require.NoError(t, err)
+	body, err := resp.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, response.OK, resp.StatusLine.StatusCode)
+	assert.Equal(t, "hello", string(body))
+	echo := func(key string) string {
+		val, _ := resp.Headers.Get("Echo-" + key)
+		return val
+	}
+	assert.Equal(t, "PATCH", echo("Method"))
+	assert.Equal(t, "/base/echo?x=1&y=%20", echo("Uri"))
+	assert.Equal(t, strings.TrimPrefix(upstream.URL, "http://"), echo("Host"))
+	assert.Equal(t, "kept", echo("X-Custom"))
+	assert.Equal(t, "", echo("X-Hop"))
+	assert.Equal(t, "", echo("Keep-Alive"))
+	assert.Equal(t, "10.0.0.1, 127.0.0.1", echo("X-Forwarded-For"))
+	assert.Equal(t, addr, echo("X-Forwarded-Host"))
+	assert.Equal(t, "http", echo("X-Forwarded-Proto"))
+	assert.Equal(t, fmt.Sprintf("for=127.0.0.1;host=%q;proto=http", addr), echo("Forwarded"))
+	assert.Equal(t, "abc", echo("Trailer"))
+	assert.False(t, resp.Headers.Has("X-Secret"))
+
+	// Test: Status and reason phrase are passed through
+	resp, err = c.Get(base + "/teapot")
+	require.NoError(t, err)
+	body, _ = resp.ReadBody()
+	assert.Equal(t, response.StatusCode(http.StatusTeapot), resp.StatusLine.StatusCode)
+	assert.Equal(t, "I'm a teapot", resp.StatusLine.ReasonPhrase)
+	assert.Equal(t, "short and stout", string(body))
+
+	// Test: Response is streamed as it arrives, followed by its trailers
+	resp, err = c.Get(base + "/stream")
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(resp.BodyReader, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "first", string(buf))
+	close(release)
+	body, err = resp.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(body))
+	done, _ := resp.Trailers.Get("X-Done")
+	assert.Equal(t, "yes", done)
+
+	// Test: Upstream that does not answer in time
+	resp, err = c.Get(base + "/slow")
+	require.NoError(t, err)
+	resp.ReadBody()
+	assert.Equal(t, response.GatewayTimeout, resp.StatusLine.StatusCode)
+}
+
+func TestContentDigest(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/empty":
+			w.WriteHeader(http.StatusNoContent)
+		case "/trailer":
+			w.Header().Set("Trailer", "X-Done")
+			io.WriteString(w, "streamed")
+			w.Header().Set("X-Done", "yes")
+		default:
+			w.Header().Set("Content-Length", "5")
+			io.WriteString(w, "hello")
+		}
+	}))
+	defer upstream.Close()
+
+	p, err := New(upstream.URL, WithContentDigest())
+	require.NoError(t, err)
+	srv, err := server.Serve(0, p.Handler())
+	require.NoError(t, err)
+	defer srv.Close()
+	base := fmt.Sprintf("http://127.0.0.1:%d", srv.Listener.Addr().(*net.TCPAddr).Port)
+	c := client.New()
+	defer c.CloseIdleConnections()
+	trailer := func(resp *response.Response, key string) string {
+		val, _ := resp.Trailers.Get(key)
+		return val
+	}
+
+	// Test: Body with a Content-Length is relayed chunked, followed by its hash and length
+	resp, err := c.Get(base + "/")
+	require.NoError(t, err)
+	body, err := resp.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+	assert.False(t, resp.Headers.Has("Content-Length"))
+	assert.True(t, resp.Headers.HasToken("Trailer", "X-Content-SHA256"))
+	assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256([]byte("hello"))), trailer(resp, "X-Content-SHA256"))
+	assert.Equal(t, "5", trailer(resp, "X-Content-Length"))
+
+	// Test: Trailers of the upstream are kept next to the digest
+	resp, err = c.Get(base + "/trailer")
+	require.NoError(t, err)
+	body, err = resp.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "streamed", string(body))
+	assert.Equal(t, "yes", trailer(resp, "X-Done"))
+	assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256([]byte("streamed"))), trailer(resp, "X-Content-SHA256"))
+	assert.Equal(t, "8", trailer(resp, "X-Content-Length"))
+
+	// Test: Responses without a body get no digest
+	resp, err = c.Get(base + "/empty")
+	require.NoError(t, err)
+	resp.ReadBody()
+	assert.Equal(t, response.NoContent, resp.StatusLine.StatusCode)
+	assert.False(t, resp.Headers.Has("Trailer"))
+	req, err := client.NewRequest("HEAD", base+"/", nil)
+	require.NoError(t, err)
+	resp, err = c.Do(req)
+	require.NoError(t, err)
+	resp.ReadBody()
+	val, _ := resp.Headers.Get("Content-Length")
+	assert.Equal(t, "5", val)
+	assert.False(t, resp.Headers.Has("Trailer"))
+}
+
+func TestUnreachableUpstream(t *testing.T) {
+	p, err := New("http://" + deadAddr(t))
+	require.NoError(t, err)
+	srv, err := server.Serve(0, p.Handler())
+	require.NoError(t, err)
+	defer srv.Close()
+
+	resp, err := client.New().Get(fmt.Sprintf("http://127.0.0.1:%d/", srv.Listener.Addr().(*net.TCPAddr).Port))
+	require.NoError(t, err)
+	resp.ReadBody()
+	assert.Equal(t, response.BadGateway, resp.StatusLine.StatusCode)
+
+	_, err = New("/relative")
+	require.Error(t, err)
+}
@@ -0,0 +1,118 @@
+package proxy
+
+import (
+	"hash/crc32"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/TheBarnakhil/httpfromtcp/internal/request"
+)
+
+// Strategy decides which backend of a Pool serves a request.
+type Strategy interface {
+	/*
+		Pick chooses one of backends for req, skipping those available reports false for.
+		It returns nil if none is available. backends is the same slice on every call.
+	*/
+	Pick(req *request.Request, backends []*Backend, available func(*Backend) bool) *Backend
+}
+
+// RoundRobin returns a Strategy handing requests to the available backends in turn.
+func RoundRobin() Strategy {
+	return &roundRobin{}
+}
+
+type roundRobin struct {
+	next atomic.Uint64
+}
+
+func (rr *roundRobin) Pick(_ *request.Request, backends []*Backend, available func(*Backend) bool) *Backend {
+	start := rr.next.Add(1) - 1
+	for i := range backends {
+		b := backends[(start+uint64(i))%uint64(len(backends))]
+		if available(b) {
+			return b
+		}
+	}
+	return nil
+}
+
+// LeastConnections returns a Strategy handing requests to the available backend with the fewest requests in flight.
+func LeastConnections() Strategy {
+	return leastConnections{}
+}
+
+type leastConnections struct{}
+
+func (leastConnections) Pick(_ *request.Request, backends []*Backend, available func(*Backend) bool) *Backend {
+	var best *Backend
+	for _, b := range backends {
+		if available(b) && (best == nil || b.active.Load() < best.active.Load()) {
+			best = b
+		}
+	}
+	return best
+}
+
+// hashReplicas is how many points each backend gets on the hash ring, to spread keys evenly
+const hashReplicas = 100
+
+/*
+ConsistentHash returns a Strategy sending requests with the same value of header to the
+same backend. When a backend becomes unavailable only its requests move to other backends,
+and they come back once it is available again. Requests without the header are handed
+out in turn.
+*/
+func ConsistentHash(header string) Strategy {
+	return &consistentHash{header: header}
+}
+
+type consistentHash struct {
+	header   string
+	fallback roundRobin
+
+	once sync.Once
+	ring []ringPoint
+}
+
+type ringPoint struct {
+	hash    uint32
+	backend *Backend
+}
+
+func (ch *consistentHash) Pick(req *request.Request, backends []*Backend, available func(*Backend) bool) *Backend {
+	key, ok := req.Headers.Get(ch.header)
+	if !ok {
+		return ch.fallback.Pick(req, backends, available)
+	}
+	ch.once.Do(func() {
+		for _, b := range backends {
+			for i := range hashReplicas {
+				ch.ring = append(ch.ring, ringPoint{hash: hash(b.URL.String() + "#" + strconv.Itoa(i)), backend: b})
+			}
+		}
+		slices.SortFunc(ch.ring, func(a, b ringPoint) int {
+			return int(int64(a.hash) - int64(b.hash))
+		})
+	})
+
+	// the first point at or after the key's hash that belongs to an available backend
+	h := hash(key)
+	start, _ := slices.BinarySearchFunc(ch.ring, h, func(p ringPoint, h uint32) int {
+		return int(int64(p.hash) - int64(h))
+	})
+	for i := range ch.ring {
+		p := ch.ring[(start+i)%len(ch.ring)]
+		if available(p.backend) {
+			return p.backend
+		}
+	}
+	return nil
+}
+
+// hash places s on the ring, CRC-32 spreads the similar names of the ring points better than FNV does.
+func hash(s string) uint32 {
+	return crc32.ChecksumIEEE([]byte(s))
+}
@@ -0,0 +1,111 @@
+package request
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// body streams a request body straight from the connection, following the framing announced in the headers.
+type body struct {
+	req    *Request
+	reader *bufio.Reader
+	closed bool
+	err    error
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, errors.New("error: read on closed request body")
+	}
+	return b.read(p)
+}
+
+func (b *body) Close() error {
+	b.closed = true
+	return nil
+}
+
+func (b *body) read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	r := b.req
+
+	// Chunk size lines and trailers sit between the data, get past them first
+	err := r.parseFrom(b.reader, func() bool { return r.readingData() || r.ParserState == Done })
+	if err != nil {
+		b.err = err
+		return 0, err
+	}
+	if r.ParserState == Done {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	// Never read past the current body or chunk, anything after it belongs to the connection
+	if len(p) > r.bodyRemaining {
+		p = p[:r.bodyRemaining]
+	}
+	n, err := b.reader.Read(p)
+	r.bodyRemaining -= n
+	if r.bodyRemaining == 0 {
+		if r.ParserState == ParsingBody {
+			r.ParserState = Done
+		} else {
+			r.ParserState = ParsingChunkEnd
+		}
+	}
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		b.err = err
+		return n, err
+	}
+	return n, nil
+}
+
+/*
+ReadBody reads whatever is left of the body into r.Body and returns it.
+After it returned without an error, r.Trailers holds the trailers of a chunked body.
+*/
+func (r *Request) ReadBody() ([]byte, error) {
+	if r.BodyReader == nil {
+		return r.Body, nil
+	}
+	data, err := io.ReadAll(r.BodyReader)
+	r.Body = append(r.Body, data...)
+	return r.Body, err
+}
+
+/*
+DiscardBody reads and throws away up to max bytes of the body that the handler left unread,
+so the connection is positioned at the start of the next request.
+It returns an error if more than max bytes are left or the body could not be read,
+in which case the connection cannot be reused.
+*/
+func (r *Request) DiscardBody(max int64) error {
+	if r.body == nil {
+		return nil
+	}
+	buf := make([]byte, 4096)
+	discarded := int64(0)
+	for r.ParserState != Done {
+		if discarded > max {
+			return errors.New("error: Too much unread request body to discard")
+		}
+		chunk := buf
+		if left := max - discarded + 1; left < int64(len(chunk)) {
+			chunk = chunk[:left]
+		}
+		n, err := r.body.read(chunk)
+		discarded += int64(n)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package request
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/TheBarnakhil/httpfromtcp/internal/framing"
+)
+
+// ErrMalformedChunk is returned for a chunked body whose framing cannot be parsed.
+var ErrMalformedChunk = &ParseError{StatusCode: 400, Message: "error: Malformed chunked body"}
+
+/*
+parseChunked consumes one piece of the framing of a chunked body: a chunk size line,
+the CRLF closing a chunk or a trailer field. The chunk data itself is left to the body reader.
+Chunk extensions are accepted and ignored, trailer fields are stored in r.Trailers.
+*/
+func (r *Request) parseChunked(data []byte) (int, error) {
+	switch r.ParserState {
+	case ParsingChunkSize:
+		idx := bytes.Index(data, []byte(crlf))
+		if idx == -1 {
+			return 0, nil
+		}
+		size, err := framing.ParseChunkSize(data[:idx])
+		if err != nil {
+			return 0, fmt.Errorf("%w: %w", ErrMalformedChunk, err)
+		}
+		// compared this way round, a huge size cannot overflow the running total
+		if size > r.limits.MaxBodyBytes-r.bodyBytes {
+			return 0, ErrBodyTooLarge
+		}
+		r.bodyBytes += size
+		if size == 0 {
+			r.ParserState = ParsingTrailers
+		} else {
+			r.bodyRemaining = int(size)
+			r.ParserState = ParsingChunkData
+		}
+		return idx + 2, nil
+	case ParsingChunkEnd:
+		if len(data) < 2 {
+			return 0, nil
+		}
+		if !bytes.HasPrefix(data, []byte(crlf)) {
+			return 0, fmt.Errorf("%w: chunk data is not followed by CRLF", ErrMalformedChunk)
+		}
+		r.ParserState = ParsingChunkSize
+		return 2, nil
+	case ParsingTrailers:
+		n, done, err := r.parseHeaderLine(r.Trailers, data)
+		if err != nil {
+			return 0, err
+		}
+		if done {
+			r.ParserState = Done
+		}
+		return n, nil
+	default:
+		return 0, fmt.Errorf("unknown state")
+	}
+}
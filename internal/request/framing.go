@@ -0,0 +1,83 @@
+package request
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/TheBarnakhil/httpfromtcp/internal/framing"
+)
+
+var (
+	// ErrInvalidFraming is returned when the length of a request body cannot be determined reliably.
+	ErrInvalidFraming = &ParseError{StatusCode: 400, Message: "error: Invalid request body framing"}
+	// ErrUnknownTransferCoding is returned for a transfer coding other than chunked.
+	ErrUnknownTransferCoding = &ParseError{StatusCode: 501, Message: "error: Unknown transfer coding"}
+)
+
+/*
+bodyLength determines how the body of the request is delimited, following RFC 9112
+section 6.3 to the letter, as a server disagreeing with a proxy in front of it about
+where a request ends lets a client smuggle a request past the proxy:
+
+  - A Transfer-Encoding header means a chunked body. Chunked has to be the final coding
+    and cannot be applied twice, any other coding is ErrUnknownTransferCoding as it is
+    not implemented. HTTP/1.0 has no transfer codings, so a request claiming one is rejected.
+  - A Transfer-Encoding and a Content-Length together are rejected, instead of letting
+    the Transfer-Encoding win, since another server in the chain may have picked the length.
+  - Otherwise the Content-Length is the length of the body. It has to be a plain decimal
+    number, and if it occurs several times or as a list, all values have to be the same.
+  - Without either, there is no body.
+
+It returns whether the body is chunked, and the length of a body that is not.
+*/
+func (r *Request) bodyLength() (bool, int64, error) {
+	codings := r.Headers.Values("Transfer-Encoding")
+	lengths := r.Headers.Values("Content-Length")
+	if len(codings) != 0 {
+		if len(lengths) != 0 {
+			return false, 0, fmt.Errorf("%w: both Transfer-Encoding and Content-Length are present", ErrInvalidFraming)
+		}
+		if r.RequestLine.HttpVersion == "1.0" {
+			return false, 0, fmt.Errorf("%w: Transfer-Encoding in an HTTP/1.0 request", ErrInvalidFraming)
+		}
+		if err := checkChunked(codings); err != nil {
+			return false, 0, err
+		}
+		return true, 0, nil
+	}
+	if len(lengths) == 0 {
+		return false, 0, nil
+	}
+	length, err := framing.ParseContentLength(lengths)
+	if err != nil {
+		return false, 0, fmt.Errorf("%w: %w", ErrInvalidFraming, err)
+	}
+	return false, length, nil
+}
+
+// checkChunked makes sure the transfer codings listed in the Transfer-Encoding values end with a single chunked.
+func checkChunked(values []string) error {
+	var codings []string
+	for _, value := range values {
+		for _, coding := range strings.Split(value, ",") {
+			// parameters, as in "gzip;q=1", are part of the coding
+			coding = strings.Trim(coding, " \t")
+			if coding == "" {
+				return fmt.Errorf("%w: empty transfer coding in %q", ErrInvalidFraming, values)
+			}
+			codings = append(codings, coding)
+		}
+	}
+	for i, coding := range codings {
+		last := i == len(codings)-1
+		switch {
+		case strings.EqualFold(coding, "chunked") && !last:
+			return fmt.Errorf("%w: chunked is not the final transfer coding in %q", ErrInvalidFraming, values)
+		case !strings.EqualFold(coding, "chunked") && last:
+			return fmt.Errorf("%w: chunked is not the final transfer coding in %q", ErrInvalidFraming, values)
+		case !strings.EqualFold(coding, "chunked"):
+			return fmt.Errorf("%w %q", ErrUnknownTransferCoding, coding)
+		}
+	}
+	return nil
+}
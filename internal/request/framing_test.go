@@ -0,0 +1,115 @@
+package request
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/TheBarnakhil/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// errMalformed stands for any error other than the framing sentinels, e.g. from header parsing
+var errMalformed = errors.New("malformed")
+
+func TestSmugglingVectors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// headers and body, following "POST / HTTP/1.1\r\nHost: localhost\r\n"
+		raw  string
+		err  error
+		body string
+	}{
+		// CL.CL
+		{"conflicting Content-Length fields", "Content-Length: 5\r\nContent-Length: 7\r\n\r\nhellohello", ErrInvalidFraming, ""},
+		{"conflicting Content-Length list", "Content-Length: 5, 7\r\n\r\nhellohello", ErrInvalidFraming, ""},
+		{"repeated identical Content-Length", "Content-Length: 5\r\nContent-Length: 5\r\n\r\nhello", nil, "hello"},
+		{"identical Content-Length list", "Content-Length: 5,5\r\n\r\nhello", nil, "hello"},
+		{"empty element in Content-Length list", "Content-Length: 5,\r\n\r\nhello", ErrInvalidFraming, ""},
+
+		// malformed lengths
+		{"negative Content-Length", "Content-Length: -5\r\n\r\nhello", ErrInvalidFraming, ""},
+		{"signed Content-Length", "Content-Length: +5\r\n\r\nhello", ErrInvalidFraming, ""},
+		{"hex Content-Length", "Content-Length: 0x5\r\n\r\nhello", ErrInvalidFraming, ""},
+		{"exponent Content-Length", "Content-Length: 5e0\r\n\r\nhello", ErrInvalidFraming, ""},
+		{"Content-Length with inner space", "Content-Length: 5 5\r\n\r\nhello", ErrInvalidFraming, ""},
+		{"empty Content-Length", "Content-Length:\r\n\r\n", ErrInvalidFraming, ""},
+		{"overflowing Content-Length", "Content-Length: 99999999999999999999\r\n\r\nhello", ErrInvalidFraming, ""},
+		{"Content-Length with leading zeros", "Content-Length: 005\r\n\r\nhello", nil, "hello"},
+
+		// CL.TE and TE.CL
+		{"Transfer-Encoding after Content-Length", "Content-Length: 6\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\nG", ErrInvalidFraming, ""},
+		{"Content-Length after Transfer-Encoding", "Transfer-Encoding: chunked\r\nContent-Length: 4\r\n\r\n5c\r\nGPOST", ErrInvalidFraming, ""},
+
+		// TE.TE obfuscation
+		{"chunked twice", "Transfer-Encoding: chunked, chunked\r\n\r\n0\r\n\r\n", ErrInvalidFraming, ""},
+		{"chunked not final", "Transfer-Encoding: chunked, identity\r\n\r\n0\r\n\r\n", ErrInvalidFraming, ""},
+		{"identity only", "Transfer-Encoding: identity\r\n\r\n0\r\n\r\n", ErrInvalidFraming, ""},
+		{"misspelled chunked", "Transfer-Encoding: xchunked\r\n\r\n0\r\n\r\n", ErrInvalidFraming, ""},
+		{"chunked with a parameter", "Transfer-Encoding: chunked;q=1\r\n\r\n0\r\n\r\n", ErrInvalidFraming, ""},
+		{"empty Transfer-Encoding", "Transfer-Encoding:\r\n\r\n0\r\n\r\n", ErrInvalidFraming, ""},
+		{"unknown coding before chunked", "Transfer-Encoding: gzip, chunked\r\n\r\n0\r\n\r\n", ErrUnknownTransferCoding, ""},
+		{"unknown coding in another field", "Transfer-Encoding: gzip\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", ErrUnknownTransferCoding, ""},
+		{"chunked in another case", "transfer-encoding: CHUNKED\r\n\r\n5\r\nhello\r\n0\r\n\r\n", nil, "hello"},
+		{"chunked after a tab", "Transfer-Encoding:\tchunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n", nil, "hello"},
+		{"vertical tab after chunked", "Transfer-Encoding: chunked\v\r\n\r\n0\r\n\r\n", errMalformed, ""},
+		{"form feed before chunked", "Transfer-Encoding: \fchunked\r\n\r\n0\r\n\r\n", errMalformed, ""},
+		{"tab before the colon", "Transfer-Encoding\t: chunked\r\n\r\n0\r\n\r\n", errMalformed, ""},
+		{"space before the colon", "Transfer-Encoding : chunked\r\n\r\n0\r\n\r\n", errMalformed, ""},
+		{"bare LF hiding a field", "X-Foo: bar\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", errMalformed, ""},
+		{"Transfer-Encoding folded into the previous field", " Transfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n", headers.ErrObsoleteLineFolding, ""},
+		{"Content-Length folded after a tab", "\tContent-Length: 5\r\n\r\nhello", headers.ErrObsoleteLineFolding, ""},
+
+		// Host
+		{"second Host field", "Host: evil.example\r\nContent-Length: 5\r\n\r\nhello", ErrInvalidHost, ""},
+
+		// chunk sizes
+		{"chunk size with leading space", "Transfer-Encoding: chunked\r\n\r\n 5\r\nhello\r\n0\r\n\r\n", errMalformed, ""},
+		{"signed chunk size", "Transfer-Encoding: chunked\r\n\r\n+5\r\nhello\r\n0\r\n\r\n", errMalformed, ""},
+		{"hex prefixed chunk size", "Transfer-Encoding: chunked\r\n\r\n0x5\r\nhello\r\n0\r\n\r\n", errMalformed, ""},
+		{"overflowing chunk size", "Transfer-Encoding: chunked\r\n\r\nffffffffffffffff1\r\nhello\r\n0\r\n\r\n", errMalformed, ""},
+		{"chunk size with whitespace before an extension", "Transfer-Encoding: chunked\r\n\r\n5 ;ext=1\r\nhello\r\n0\r\n\r\n", nil, "hello"},
+
+		// chunk extensions
+		{"bare LF in chunk extension", "Transfer-Encoding: chunked\r\n\r\n5;ext\nX\r\nhello\r\n0\r\n\r\n", ErrMalformedChunk, ""},
+		{"unterminated quoted extension", "Transfer-Encoding: chunked\r\n\r\n5;ext=\"abc\r\nhello\r\n0\r\n\r\n", ErrMalformedChunk, ""},
+		{"quoted extension", "Transfer-Encoding: chunked\r\n\r\n5 ; a=b ; c=\"d;\\\"e\"\r\nhello\r\n0\r\n\r\n", nil, "hello"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost\r\n" + tc.raw))
+			switch tc.err {
+			case nil:
+				require.NoError(t, err)
+				assert.Equal(t, tc.body, string(r.Body))
+			case errMalformed:
+				require.Error(t, err)
+				assert.NotErrorIs(t, err, ErrInvalidFraming)
+			default:
+				require.ErrorIs(t, err, tc.err)
+			}
+		})
+	}
+
+	// Test: HTTP/1.1 needs a single Host, HTTP/1.0 may go without
+	for raw, err := range map[string]error{
+		"GET / HTTP/1.1\r\n\r\n":                       ErrInvalidHost,
+		"GET / HTTP/1.1\r\nHost: a, b\r\n\r\n":         ErrInvalidHost,
+		"GET / HTTP/1.0\r\nHost: a\r\nHost: b\r\n\r\n": ErrInvalidHost,
+		"GET / HTTP/1.0\r\n\r\n":                       nil,
+	} {
+		_, got := RequestFromReader(strings.NewReader(raw))
+		assert.ErrorIs(t, got, err, raw)
+	}
+
+	// Test: HTTP/1.0 has no transfer codings
+	_, err := RequestFromReader(strings.NewReader("POST / HTTP/1.0\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n"))
+	require.ErrorIs(t, err, ErrInvalidFraming)
+
+	// Test: Framing fields in trailers do not change the framing
+	r, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"5\r\nhello\r\n0\r\nContent-Length: 100\r\n\r\nGET /next HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+	assert.False(t, r.Headers.Has("Content-Length"))
+}
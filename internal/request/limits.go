@@ -0,0 +1,71 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+/*
+Limits bounds how much a client may send in a single request.
+A zero field falls back to the matching field of DefaultLimits.
+*/
+type Limits struct {
+	// MaxRequestLineBytes bounds the request line, not counting its CRLF
+	MaxRequestLineBytes int
+	// MaxHeaderBytes bounds all header lines together, trailers of a chunked body included
+	MaxHeaderBytes int
+	// MaxHeaderCount bounds the number of header lines, trailers included
+	MaxHeaderCount int
+	// MaxBodyBytes bounds the decoded body
+	MaxBodyBytes int64
+}
+
+var DefaultLimits = Limits{
+	MaxRequestLineBytes: 8 << 10,
+	MaxHeaderBytes:      1 << 20,
+	MaxHeaderCount:      100,
+	MaxBodyBytes:        10 << 20,
+}
+
+var (
+	ErrRequestLineTooLong = &ParseError{StatusCode: 414, Message: "error: Request line is too long"}
+	ErrHeadersTooLarge    = &ParseError{StatusCode: 431, Message: "error: Request headers are too large"}
+	ErrBodyTooLarge       = &ParseError{StatusCode: 413, Message: "error: Request body is too large"}
+)
+
+func (l Limits) withDefaults() Limits {
+	if l.MaxRequestLineBytes == 0 {
+		l.MaxRequestLineBytes = DefaultLimits.MaxRequestLineBytes
+	}
+	if l.MaxHeaderBytes == 0 {
+		l.MaxHeaderBytes = DefaultLimits.MaxHeaderBytes
+	}
+	if l.MaxHeaderCount == 0 {
+		l.MaxHeaderCount = DefaultLimits.MaxHeaderCount
+	}
+	if l.MaxBodyBytes == 0 {
+		l.MaxBodyBytes = DefaultLimits.MaxBodyBytes
+	}
+	return l
+}
+
+/*
+NewReader wraps r in a *bufio.Reader that can be passed to StreamingRequestFromReaderWithLimits
+for every request on a connection. Its buffer fits the longest request line l allows.
+A single header line must fit into the buffer as well.
+*/
+func (l Limits) NewReader(r io.Reader) *bufio.Reader {
+	l = l.withDefaults()
+	return bufio.NewReaderSize(r, max(bufferSize, l.MaxRequestLineBytes+len(crlf)))
+}
+
+// lineTooLong reports whether the line at the start of data is, or is bound to become, longer than max bytes.
+func lineTooLong(data []byte, max int) bool {
+	idx := bytes.Index(data, []byte(crlf))
+	if idx == -1 {
+		// the last byte may be the CR of the missing CRLF
+		return len(data) > max+1
+	}
+	return idx > max
+}
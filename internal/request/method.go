@@ -0,0 +1,51 @@
+package request
+
+import "slices"
+
+// Methods defined by RFC 9110 section 9 and RFC 5789 (PATCH).
+const (
+	MethodGet     = "GET"
+	MethodHead    = "HEAD"
+	MethodPost    = "POST"
+	MethodPut     = "PUT"
+	MethodDelete  = "DELETE"
+	MethodConnect = "CONNECT"
+	MethodOptions = "OPTIONS"
+	MethodTrace   = "TRACE"
+	MethodPatch   = "PATCH"
+)
+
+/*
+StandardMethods returns the methods defined by RFC 9110 plus PATCH, the ones a server
+implements unless it is configured otherwise.
+*/
+func StandardMethods() []string {
+	return []string{MethodGet, MethodHead, MethodPost, MethodPut, MethodDelete, MethodConnect, MethodOptions, MethodTrace, MethodPatch}
+}
+
+/*
+IsSafe reports whether method is safe, RFC 9110 section 9.2.1: it only retrieves
+and does not change anything on the server, so it can be sent speculatively or cached.
+*/
+func IsSafe(method string) bool {
+	return slices.Contains([]string{MethodGet, MethodHead, MethodOptions, MethodTrace}, method)
+}
+
+/*
+IsIdempotent reports whether method is idempotent, RFC 9110 section 9.2.2: sending the
+same request several times has the same effect as sending it once, so it can be retried
+when the connection fails before the response arrives.
+*/
+func IsIdempotent(method string) bool {
+	return IsSafe(method) || method == MethodPut || method == MethodDelete
+}
+
+// IsSafe reports whether the method of r is safe, see the function of the same name.
+func (r *Request) IsSafe() bool {
+	return IsSafe(r.RequestLine.Method)
+}
+
+// IsIdempotent reports whether the method of r is idempotent, see the function of the same name.
+func (r *Request) IsIdempotent() bool {
+	return IsIdempotent(r.RequestLine.Method)
+}
@@ -1,23 +1,45 @@
 package request
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
-	"log"
-	"strconv"
 	"strings"
-	"unicode"
 
 	"github.com/TheBarnakhil/httpfromtcp/internal/headers"
 )
 
 type Request struct {
 	RequestLine RequestLine
+	// Target is the request target split into its parts, with the path and query decoded
+	Target      Target
 	ParserState internal
-	Headers     headers.Headers
-	Body        []byte
+	Headers     *headers.Headers
+	// BodyReader streams the body from the connection as it is read
+	BodyReader io.ReadCloser
+	// Body holds the whole body once ReadBody has been called
+	Body []byte
+	// Trailers holds the trailer fields sent after the last chunk of a chunked body,
+	// they are only available once the body has been read completely
+	Trailers *headers.Headers
+	// RemoteAddr is the address of the client, set by the server that received the request
+	RemoteAddr string
+	// TLS describes the connection the request came in on, nil if it was not encrypted
+	TLS *tls.ConnectionState
+
+	body   *body
+	limits Limits
+	// values captured from the path by a router
+	pathValues map[string]string
+	// bytes left in the body or in the chunk currently being read
+	bodyRemaining int
+	// running totals checked against limits
+	headerBytes int
+	headerCount int
+	bodyBytes   int64
 }
 
 type RequestLine struct {
@@ -35,47 +57,157 @@ const (
 	Done
 	ParsingHeaders
 	ParsingBody
+	ParsingChunkSize
+	ParsingChunkData
+	ParsingChunkEnd
+	ParsingTrailers
 )
 
-const bufferLen = 8
+// bufferSize is the size of the buffered reader wrapped around the connection.
+// A single request line or header line must fit into it.
+const bufferSize = 8192
 
+/*
+RequestFromReader parses a single request from reader, including its whole body
+which is stored in Request.Body.
+It is a convenience over StreamingRequestFromReader for callers that want the body in memory.
+*/
 func RequestFromReader(reader io.Reader) (*Request, error) {
+	req, err := StreamingRequestFromReader(reader)
+	if err != nil {
+		return req, err
+	}
+	if _, err := req.ReadBody(); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
 
-	buffer := make([]byte, bufferLen)
-	readToIndex := 0
+/*
+StreamingRequestFromReader parses the request line and the headers of a single request
+from reader and stops there. The body is left on the reader and can be streamed through
+Request.BodyReader, which honors Content-Length and chunked framing.
+If reader is a *bufio.Reader it is used as is, and only the bytes belonging to
+the request are consumed from it, so the same reader can be passed in again to
+parse the next request on a persistent connection once the body has been read.
+It returns io.EOF if the reader is exhausted before any byte of a request was read,
+and an error wrapping a *ParseError, which holds the status code to answer with,
+if the request is malformed.
+*/
+func StreamingRequestFromReader(reader io.Reader) (*Request, error) {
+	return StreamingRequestFromReaderWithLimits(reader, DefaultLimits)
+}
+
+/*
+StreamingRequestFromReaderWithLimits works like StreamingRequestFromReader but rejects
+requests exceeding limits with ErrRequestLineTooLong, ErrHeadersTooLarge or ErrBodyTooLarge.
+A Content-Length above the limit is rejected right away, a chunked body once it grows past it.
+*/
+func StreamingRequestFromReaderWithLimits(reader io.Reader, limits Limits) (*Request, error) {
+	limits = limits.withDefaults()
+	br, ok := reader.(*bufio.Reader)
+	if !ok {
+		br = limits.NewReader(reader)
+	}
 
 	req := &Request{
 		ParserState: Initialized,
 		Headers:     headers.NewHeaders(),
+		Trailers:    headers.NewHeaders(),
+		limits:      limits,
 	}
 
-	for req.ParserState != Done {
-		if len(buffer) <= readToIndex {
-			bufferCopy := make([]byte, len(buffer)*2)
-			copy(bufferCopy, buffer)
-			buffer = bufferCopy
+	err := req.parseFrom(br, req.headDone)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, err
 		}
+		return req, err
+	}
+	req.body = &body{req: req, reader: br}
+	req.BodyReader = req.body
+	return req, nil
+}
 
-		numBytesRead, err := reader.Read(buffer[readToIndex:])
+/*
+parseFrom feeds the data buffered in br to the parser, reading more from the
+underlying reader whenever the parser needs it, until stop reports true.
+Only the bytes the parser consumed are discarded from br.
+*/
+func (r *Request) parseFrom(br *bufio.Reader, stop func() bool) error {
+	need := 1
+	for !stop() {
+		_, err := br.Peek(need)
 		if err != nil {
 			if errors.Is(err, io.EOF) {
-				if req.ParserState != Done {
-					return nil, fmt.Errorf("incomplete request, in state: %d, read n bytes on EOF: %d", req.ParserState, numBytesRead)
+				if r.ParserState == Initialized && br.Buffered() == 0 {
+					return io.EOF
 				}
-				break
+				return fmt.Errorf("incomplete request, in state: %d, read n bytes on EOF: %d: %w", r.ParserState, br.Buffered(), io.ErrUnexpectedEOF)
 			}
-			return nil, err
+			if errors.Is(err, bufio.ErrBufferFull) {
+				switch r.ParserState {
+				case Initialized:
+					return ErrRequestLineTooLong
+				case ParsingHeaders, ParsingTrailers:
+					return ErrHeadersTooLarge
+				}
+				return fmt.Errorf("%w: line is longer than %d bytes", ErrMalformedChunk, br.Size())
+			}
+			return err
 		}
-		readToIndex += numBytesRead
-		parsedNum, err := req.parse(buffer[:readToIndex])
+
+		data, _ := br.Peek(br.Buffered())
+		parsedNum, err := r.parse(data)
 		if err != nil {
-			return req, errors.New("error: Unable to parse from buffer " + err.Error())
+			return fmt.Errorf("error: Unable to parse from buffer %w", err)
 		}
-		copy(buffer, buffer[parsedNum:])
-		readToIndex -= parsedNum
+		br.Discard(parsedNum)
 
+		if parsedNum == 0 {
+			// just need more data than is currently buffered
+			need = len(data) + 1
+		} else {
+			need = 1
+		}
 	}
-	return req, nil
+	return nil
+}
+
+// headDone reports whether the request line and all headers have been parsed.
+func (r *Request) headDone() bool {
+	return r.ParserState != Initialized && r.ParserState != ParsingHeaders
+}
+
+// readingData reports whether the parser is positioned in front of body bytes, which are left to the body reader.
+func (r *Request) readingData() bool {
+	return r.ParserState == ParsingBody || r.ParserState == ParsingChunkData
+}
+
+// PathValue returns the value a router captured for the wildcard name, or an empty string if there is none.
+func (r *Request) PathValue(name string) string {
+	return r.pathValues[name]
+}
+
+// SetPathValue stores value as the value of the wildcard name, so PathValue can return it.
+func (r *Request) SetPathValue(name, value string) {
+	if r.pathValues == nil {
+		r.pathValues = make(map[string]string)
+	}
+	r.pathValues[name] = value
+}
+
+/*
+KeepAlive reports whether the client is willing to send another request on the
+same connection once this one has been answered.
+HTTP/1.1 connections are persistent unless the client sends "Connection: close",
+HTTP/1.0 connections only if it sends "Connection: keep-alive".
+*/
+func (r *Request) KeepAlive() bool {
+	if r.RequestLine.HttpVersion == "1.0" {
+		return r.Headers.HasToken("Connection", "keep-alive")
+	}
+	return !r.Headers.HasToken("Connection", "close")
 }
 
 func parseRequestLine(data []byte) (*RequestLine, int, error) {
@@ -92,20 +224,13 @@ func parseRequestLine(data []byte) (*RequestLine, int, error) {
 		return &RequestLine{}, idx, err
 	}
 
-	if !strings.Contains(reqLine.RequestTarget, "/") {
-		return &RequestLine{}, idx, errors.New("invalid request target")
-	}
-
-	// Check if http version is 1.1
-	if reqLine.HttpVersion != "1.1" {
-		return &RequestLine{}, idx, errors.New("invalid http version")
+	if err := checkVersion(reqLine.HttpVersion); err != nil {
+		return &RequestLine{}, idx, err
 	}
 
-	// Check if method contains only cap letters
-	for _, char := range reqLine.Method {
-		if unicode.IsLower(char) {
-			return &RequestLine{}, idx, errors.New("method names are required to be uppercase")
-		}
+	// Methods are case-sensitive tokens, RFC 9110 section 9.1
+	if !headers.IsToken(reqLine.Method) {
+		return &RequestLine{}, idx, fmt.Errorf("%w %q", ErrInvalidMethod, reqLine.Method)
 	}
 
 	return reqLine, idx + 2, nil
@@ -119,18 +244,87 @@ It returns a pointer to the created struct and an error if the length of the sec
 func splitRequestLineString(str string) (*RequestLine, error) {
 	sections := strings.Split(str, " ")
 	if len(sections) != 3 {
-		return &RequestLine{}, errors.New("there are not enough sections present in the request line")
+		return &RequestLine{}, fmt.Errorf("%w: there are not enough sections present in the request line", ErrMalformedRequestLine)
 	}
 	httpVersion := strings.Split(sections[2], "/")
-	if len(httpVersion) != 2 {
-		return &RequestLine{}, errors.New("invalid http version string")
+	if len(httpVersion) != 2 || httpVersion[0] != "HTTP" {
+		return &RequestLine{}, fmt.Errorf("%w: invalid http version string %q", ErrMalformedRequestLine, sections[2])
 	}
 	return &RequestLine{HttpVersion: httpVersion[1], RequestTarget: sections[1], Method: sections[0]}, nil
 }
 
+// ParseError is the type of the errors for malformed requests, see headers.ParseError.
+type ParseError = headers.ParseError
+
+var (
+	// ErrMalformedRequestLine is returned for a request line that is not a method, a target and a version.
+	ErrMalformedRequestLine = &ParseError{StatusCode: 400, Message: "error: Malformed request line"}
+	// ErrInvalidMethod is returned for a method that is not a token.
+	ErrInvalidMethod = &ParseError{StatusCode: 400, Message: "error: Invalid method"}
+	// ErrInvalidHost is returned for a request with several Host fields, or an HTTP/1.1 request without one.
+	ErrInvalidHost = &ParseError{StatusCode: 400, Message: "error: Invalid Host header"}
+	// ErrVersionNotSupported is returned for requests in an HTTP version other than 1.x.
+	ErrVersionNotSupported = &ParseError{StatusCode: 505, Message: "error: HTTP version not supported"}
+)
+
+/*
+checkVersion accepts the HTTP/1.x versions, a single digit each, RFC 9112 section 2.3.
+A higher minor version than 1.1 is answered like 1.1, as it is compatible with it.
+Other major versions, like HTTP/2 sent in the wrong place, are ErrVersionNotSupported.
+*/
+func checkVersion(version string) error {
+	major, minor, hasMinor := strings.Cut(version, ".")
+	if !isDigit(major) || hasMinor && !isDigit(minor) {
+		return fmt.Errorf("%w: invalid http version %q", ErrMalformedRequestLine, version)
+	}
+	if major != "1" {
+		return fmt.Errorf("%w: %s", ErrVersionNotSupported, version)
+	}
+	if !hasMinor {
+		return fmt.Errorf("%w: invalid http version %q", ErrMalformedRequestLine, version)
+	}
+	return nil
+}
+
+func isDigit(s string) bool {
+	return len(s) == 1 && s[0] >= '0' && s[0] <= '9'
+}
+
+/*
+checkHost makes sure the request names a single host, RFC 9112 section 3.2: HTTP/1.1 requests
+need a Host field, and two of them, or a list in one, leave it up to the reader which one counts.
+*/
+func (r *Request) checkHost() error {
+	hosts := r.Headers.Values("Host")
+	switch {
+	case len(hosts) == 0 && r.RequestLine.HttpVersion != "1.0":
+		return fmt.Errorf("%w: missing in an HTTP/%s request", ErrInvalidHost, r.RequestLine.HttpVersion)
+	case len(hosts) > 1 || len(hosts) == 1 && strings.Contains(hosts[0], ","):
+		return fmt.Errorf("%w: several hosts %q", ErrInvalidHost, hosts)
+	}
+	return nil
+}
+
+// parseHeaderLine parses one header or trailer line into h, enforcing the header limits.
+func (r *Request) parseHeaderLine(h *headers.Headers, data []byte) (int, bool, error) {
+	if lineTooLong(data, r.limits.MaxHeaderBytes-r.headerBytes) {
+		return 0, false, ErrHeadersTooLarge
+	}
+	n, done, err := h.Parse(data)
+	if err != nil || done || n == 0 {
+		return n, done, err
+	}
+	r.headerBytes += n
+	r.headerCount++
+	if r.headerCount > r.limits.MaxHeaderCount {
+		return 0, false, ErrHeadersTooLarge
+	}
+	return n, done, nil
+}
+
 func (r *Request) parse(data []byte) (int, error) {
 	totalBytesParsed := 0
-	for r.ParserState != Done {
+	for r.ParserState != Done && !r.readingData() {
 		n, err := r.parseSingle(data[totalBytesParsed:])
 		if err != nil {
 			return 0, err
@@ -146,6 +340,9 @@ func (r *Request) parse(data []byte) (int, error) {
 func (r *Request) parseSingle(data []byte) (int, error) {
 	switch r.ParserState {
 	case Initialized:
+		if lineTooLong(data, r.limits.MaxRequestLineBytes) {
+			return 0, ErrRequestLineTooLong
+		}
 		requestLine, n, err := parseRequestLine(data)
 		if err != nil {
 			// something actually went wrong
@@ -155,37 +352,44 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 			// just need more data
 			return 0, nil
 		}
+		// set first, so an error response can be written in the client's version
 		r.RequestLine = *requestLine
+		r.Target, err = ParseTarget(requestLine.Method, requestLine.RequestTarget)
+		if err != nil {
+			return 0, err
+		}
 		r.ParserState = ParsingHeaders
 		return n, nil
 	case ParsingHeaders:
-		n, done, err := r.Headers.Parse(data)
+		n, done, err := r.parseHeaderLine(r.Headers, data)
 		if err != nil {
 			return 0, err
 		}
 		if done {
-			r.ParserState = ParsingBody
+			if err := r.checkHost(); err != nil {
+				return 0, err
+			}
+			chunked, size, err := r.bodyLength()
+			if err != nil {
+				return 0, err
+			}
+			if chunked {
+				r.ParserState = ParsingChunkSize
+				return n, nil
+			}
+			if size > r.limits.MaxBodyBytes {
+				return 0, ErrBodyTooLarge
+			}
+			if size == 0 {
+				r.ParserState = Done
+			} else {
+				r.bodyRemaining = int(size)
+				r.ParserState = ParsingBody
+			}
 		}
 		return n, nil
-	case ParsingBody:
-		valString, ok := r.Headers.Get("Content-Length")
-		if !ok {
-			r.ParserState = Done
-			return len(data), nil
-		}
-		log.Println(valString, "val", string(data))
-		size, err := strconv.Atoi(valString)
-		if err != nil {
-			return 0, fmt.Errorf("error: Invalid value (%s) in request header: %w", valString, err)
-		}
-		r.Body = append(r.Body, data...)
-		if len(r.Body) > size {
-			return 0, errors.New("error: Request body is bigger than specified in header")
-		}
-		if len(r.Body) == size {
-			r.ParserState = Done
-		}
-		return len(data), nil
+	case ParsingChunkSize, ParsingChunkEnd, ParsingTrailers:
+		return r.parseChunked(data)
 	case Done:
 		return 0, fmt.Errorf("error: trying to read data in a done state")
 	default:
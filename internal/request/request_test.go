@@ -1,10 +1,14 @@
 package request
 
 import (
+	"bufio"
+	"errors"
 	"io"
 	"log"
+	"strings"
 	"testing"
 
+	"github.com/TheBarnakhil/httpfromtcp/internal/headers"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -92,6 +96,63 @@ func TestRequestLineParse(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestMethods(t *testing.T) {
+	// Test: Methods are case-sensitive tokens, extension methods included
+	for _, method := range []string{"GET", "purge", "M-SEARCH", "BREW"} {
+		r, err := RequestFromReader(strings.NewReader(method + " / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err, method)
+		assert.Equal(t, method, r.RequestLine.Method)
+	}
+
+	// Test: Methods with characters outside of tokens
+	for _, method := range []string{"G@T", "GET,POST", "G\xc9T", "\"GET\""} {
+		_, err := RequestFromReader(strings.NewReader(method + " / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.Error(t, err, method)
+	}
+
+	// Test: Safe and idempotent methods
+	assert.True(t, IsSafe(MethodGet))
+	assert.True(t, IsSafe(MethodTrace))
+	assert.False(t, IsSafe(MethodPut))
+	assert.False(t, IsSafe("get"))
+	assert.True(t, IsIdempotent(MethodPut))
+	assert.True(t, IsIdempotent(MethodDelete))
+	assert.False(t, IsIdempotent(MethodPost))
+	assert.False(t, IsIdempotent(MethodPatch))
+	r := &Request{RequestLine: RequestLine{Method: MethodHead}}
+	assert.True(t, r.IsSafe())
+	assert.True(t, r.IsIdempotent())
+}
+
+func TestVersions(t *testing.T) {
+	// Test: HTTP/1.0 closes the connection unless asked to keep it alive
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.0\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", r.RequestLine.HttpVersion)
+	assert.False(t, r.KeepAlive())
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.0\r\nConnection: Keep-Alive\r\n\r\n"))
+	require.NoError(t, err)
+	assert.True(t, r.KeepAlive())
+
+	// Test: Higher minor version
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.2\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	assert.True(t, r.KeepAlive())
+
+	// Test: Other major versions are not supported
+	for _, version := range []string{"HTTP/2.0", "HTTP/2", "HTTP/3.0", "HTTP/0.9"} {
+		_, err = RequestFromReader(strings.NewReader("GET / " + version + "\r\n\r\n"))
+		require.ErrorIs(t, err, ErrVersionNotSupported, version)
+	}
+
+	// Test: Malformed versions
+	for _, version := range []string{"HTTP/1", "HTTP/1.", "HTTP/1.10", "HTTP/x.1", "HTTPS/1.1", "http/1.1"} {
+		_, err = RequestFromReader(strings.NewReader("GET / " + version + "\r\n\r\n"))
+		require.Error(t, err, version)
+		require.NotErrorIs(t, err, ErrVersionNotSupported, version)
+	}
+}
+
 func TestHeadersParse(t *testing.T) {
 	// Test: Standard Headers
 	reader := &chunkReader{
@@ -101,19 +162,19 @@ func TestHeadersParse(t *testing.T) {
 	r, err := RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, "localhost:42069", get(r.Headers, "host"))
+	assert.Equal(t, "curl/7.81.0", get(r.Headers, "user-agent"))
+	assert.Equal(t, "*/*", get(r.Headers, "accept"))
 
 	// Test: Empty Headers
 	reader = &chunkReader{
-		data:            "GET / HTTP/1.1\r\n\r\n",
+		data:            "GET / HTTP/1.0\r\n\r\n",
 		numBytesPerRead: 3,
 	}
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Empty(t, r.Headers)
+	assert.Equal(t, 0, r.Headers.Len())
 
 	// Test: Malformed Header
 	reader = &chunkReader{
@@ -125,13 +186,13 @@ func TestHeadersParse(t *testing.T) {
 
 	// Test: Duplicate Headers
 	reader = &chunkReader{
-		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nHost: duplicate:8080\r\n\r\n",
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nAccept: text/html\r\nAccept: */*\r\n\r\n",
 		numBytesPerRead: 3,
 	}
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069, duplicate:8080", r.Headers["host"])
+	assert.Equal(t, "text/html, */*", get(r.Headers, "accept"))
 
 	// Test: Case Insensitive Headers
 	reader = &chunkReader{
@@ -141,8 +202,8 @@ func TestHeadersParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
+	assert.Equal(t, "localhost:42069", get(r.Headers, "host"))
+	assert.Equal(t, "curl/7.81.0", get(r.Headers, "user-agent"))
 
 	// Test: Missing End of Headers
 	reader = &chunkReader{
@@ -215,6 +276,299 @@ func TestBodyParse(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestChunkedBodyParse(t *testing.T) {
+	// Test: Chunked body with extensions and trailers
+	reader := &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n" +
+			"7;name=value\r\n world!\r\n" +
+			"0\r\n" +
+			"X-Checksum: abc\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "hello world!", string(r.Body))
+	checksum, ok := r.Trailers.Get("X-Checksum")
+	assert.True(t, ok)
+	assert.Equal(t, "abc", checksum)
+
+	// Test: Empty chunked body
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"0\r\n\r\n",
+		numBytesPerRead: 1,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(r.Body))
+	assert.Equal(t, 0, r.Trailers.Len())
+
+	// Test: Invalid chunk size
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"zz\r\nhello\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Chunk data longer than chunk size
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"3\r\nhello\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Missing last chunk
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+}
+
+func TestStreamingBody(t *testing.T) {
+	// Test: Body is left on the reader until it is streamed
+	reader := bufio.NewReader(&chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n" +
+			"6\r\n world\r\n" +
+			"0\r\n" +
+			"X-Checksum: abc\r\n" +
+			"\r\n" +
+			"GET /next HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		numBytesPerRead: 4,
+	})
+	r, err := StreamingRequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Empty(t, r.Body)
+
+	buf := make([]byte, 3)
+	n, err := r.BodyReader.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hel", string(buf[:n]))
+
+	rest, err := io.ReadAll(r.BodyReader)
+	require.NoError(t, err)
+	assert.Equal(t, "lo world", string(rest))
+	checksum, _ := r.Trailers.Get("X-Checksum")
+	assert.Equal(t, "abc", checksum)
+
+	r, err = StreamingRequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+
+	// Test: Discarding an unread body
+	reader = bufio.NewReader(&chunkReader{
+		data: "POST /first HTTP/1.1\r\n" +
+			"Host: localhost\r\n" +
+			"Content-Length: 11\r\n" +
+			"\r\n" +
+			"hello world" +
+			"GET /second HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		numBytesPerRead: 5,
+	})
+	r, err = StreamingRequestFromReader(reader)
+	require.NoError(t, err)
+	require.NoError(t, r.BodyReader.Close())
+	_, err = r.BodyReader.Read(buf)
+	require.Error(t, err)
+	require.NoError(t, r.DiscardBody(1024))
+
+	r, err = StreamingRequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+
+	// Test: Body too large to discard
+	reader = bufio.NewReader(&chunkReader{
+		data: "POST /first HTTP/1.1\r\n" +
+			"Host: localhost\r\n" +
+			"Content-Length: 11\r\n" +
+			"\r\n" +
+			"hello world",
+		numBytesPerRead: 5,
+	})
+	r, err = StreamingRequestFromReader(reader)
+	require.NoError(t, err)
+	require.Error(t, r.DiscardBody(4))
+}
+
+func TestLimits(t *testing.T) {
+	limits := Limits{
+		MaxRequestLineBytes: 20,
+		MaxHeaderBytes:      40,
+		MaxHeaderCount:      2,
+		MaxBodyBytes:        5,
+	}
+
+	// Test: Request within limits
+	reader := &chunkReader{
+		data:            "GET /coffee HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err := StreamingRequestFromReaderWithLimits(reader, limits)
+	require.NoError(t, err)
+
+	// Test: Request line too long
+	reader = &chunkReader{
+		data:            "GET /a-very-long-coffee-order HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = StreamingRequestFromReaderWithLimits(reader, limits)
+	require.ErrorIs(t, err, ErrRequestLineTooLong)
+
+	// Test: Headers too large
+	reader = &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost\r\nUser-Agent: a-very-long-user-agent\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = StreamingRequestFromReaderWithLimits(reader, limits)
+	require.ErrorIs(t, err, ErrHeadersTooLarge)
+
+	// Test: Too many headers
+	reader = &chunkReader{
+		data:            "GET / HTTP/1.1\r\nA: 1\r\nB: 2\r\nC: 3\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = StreamingRequestFromReaderWithLimits(reader, limits)
+	require.ErrorIs(t, err, ErrHeadersTooLarge)
+
+	// Test: Content-Length too large
+	reader = &chunkReader{
+		data:            "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 6\r\n\r\nhello!",
+		numBytesPerRead: 3,
+	}
+	_, err = StreamingRequestFromReaderWithLimits(reader, limits)
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: Chunked body growing too large
+	reader = &chunkReader{
+		data:            "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nhel\r\n3\r\nlo!\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := StreamingRequestFromReaderWithLimits(reader, limits)
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tc := range []struct {
+		raw        string
+		err        error
+		statusCode int
+	}{
+		{"GET /coffee\r\n\r\n", ErrMalformedRequestLine, 400},
+		{"GET /coffee HTTPS/1.1\r\n\r\n", ErrMalformedRequestLine, 400},
+		{"GET /coffee HTTP/1\r\n\r\n", ErrMalformedRequestLine, 400},
+		{"G(T /coffee HTTP/1.1\r\nHost: localhost\r\n\r\n", ErrInvalidMethod, 400},
+		{"GET coffee HTTP/1.1\r\nHost: localhost\r\n\r\n", ErrInvalidTarget, 400},
+		{"GET /coffee HTTP/2.0\r\n\r\n", ErrVersionNotSupported, 505},
+		{"GET /coffee HTTP/1.1\r\nHost localhost\r\n\r\n", headers.ErrMissingColon, 400},
+		{"POST /coffee HTTP/1.1\r\nHost: localhost\r\nContent-Length: x\r\n\r\n", ErrInvalidFraming, 400},
+		{"POST /coffee HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: gzip, chunked\r\n\r\n", ErrUnknownTransferCoding, 501},
+		{"POST /coffee HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n", ErrMalformedChunk, 400},
+		{"POST /coffee HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n1\r\nabc\r\n", ErrMalformedChunk, 400},
+	} {
+		_, err := RequestFromReader(strings.NewReader(tc.raw))
+		require.ErrorIs(t, err, tc.err, tc.raw)
+		var parseErr *ParseError
+		require.ErrorAs(t, err, &parseErr, tc.raw)
+		assert.Equal(t, tc.statusCode, parseErr.StatusCode, tc.raw)
+	}
+
+	// Test: Limits carry their own status codes
+	limits := Limits{MaxRequestLineBytes: 20, MaxHeaderCount: 2, MaxBodyBytes: 1}
+	for raw, statusCode := range map[string]int{
+		"GET /a-very-long-coffee-order HTTP/1.1\r\nHost: localhost\r\n\r\n": 414,
+		"GET / HTTP/1.1\r\nA: 1\r\nB: 2\r\nC: 3\r\n\r\n":                    431,
+		"POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 2\r\n\r\nhi": 413,
+	} {
+		_, err := StreamingRequestFromReaderWithLimits(strings.NewReader(raw), limits)
+		var parseErr *ParseError
+		require.ErrorAs(t, err, &parseErr, raw)
+		assert.Equal(t, statusCode, parseErr.StatusCode, raw)
+	}
+
+	// Test: A connection cut short is not a parse error
+	_, err := RequestFromReader(strings.NewReader("GET /coffee HTTP/1.1\r\nHost: local"))
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	var parseErr *ParseError
+	assert.False(t, errors.As(err, &parseErr))
+}
+
+func TestPersistentConnection(t *testing.T) {
+	// Test: Two requests on the same reader
+	reader := bufio.NewReader(&chunkReader{
+		data: "POST /first HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello" +
+			"GET /second HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Connection: close\r\n" +
+			"\r\n",
+		numBytesPerRead: 7,
+	})
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "/first", r.RequestLine.RequestTarget)
+	assert.Equal(t, "hello", string(r.Body))
+	assert.True(t, r.KeepAlive())
+
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+	assert.False(t, r.KeepAlive())
+
+	// Test: Clean EOF between requests
+	_, err = RequestFromReader(reader)
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: Connection header with several tokens
+	reader = bufio.NewReader(&chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade, Close\r\n\r\n",
+		numBytesPerRead: 3,
+	})
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.False(t, r.KeepAlive())
+}
+
+func get(h *headers.Headers, key string) string {
+	value, _ := h.Get(key)
+	return value
+}
+
 type chunkReader struct {
 	data            string
 	numBytesPerRead int
@@ -0,0 +1,133 @@
+package request
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// TargetForm is one of the forms of a request target, RFC 9112 section 3.2.
+type TargetForm int
+
+const (
+	// OriginForm is an absolute path with an optional query, "/where?q=now"
+	OriginForm TargetForm = iota
+	// AbsoluteForm is a whole URI, sent to proxies, "http://www.example.org/pub/WWW/TheProject.html"
+	AbsoluteForm
+	// AuthorityForm is the host and port a CONNECT request tunnels to, "www.example.com:80"
+	AuthorityForm
+	// AsteriskForm is the "*" of a server-wide OPTIONS request
+	AsteriskForm
+)
+
+// ErrInvalidTarget is returned for request targets that are malformed or do not fit the method.
+var ErrInvalidTarget = &ParseError{StatusCode: 400, Message: "error: Invalid request target"}
+
+/*
+Target is a request target split into its parts.
+
+Path is percent-decoded, RawPath is the path as the client sent it, which tells "%2F"
+apart from "/". Query holds the decoded query parameters, a name may occur several times.
+Absolute form targets also fill in Scheme and Host, authority form targets only Host.
+Fragments are not part of a request target, Fragment only holds one a client sent anyway.
+*/
+type Target struct {
+	Form     TargetForm
+	Scheme   string
+	Host     string
+	Path     string
+	RawPath  string
+	RawQuery string
+	Query    url.Values
+	Fragment string
+}
+
+/*
+ParseTarget parses target, the request target of a request with method.
+CONNECT requests need the authority form, which no other method may use,
+and the asterisk form is only allowed for OPTIONS requests.
+*/
+func ParseTarget(method, target string) (Target, error) {
+	for i := 0; i < len(target); i++ {
+		if target[i] <= ' ' || target[i] == 0x7f {
+			return Target{}, fmt.Errorf("%w %q: control character", ErrInvalidTarget, target)
+		}
+	}
+
+	switch {
+	case method == MethodConnect:
+		host, port, err := net.SplitHostPort(target)
+		if err != nil || host == "" || port == "" {
+			return Target{}, fmt.Errorf("%w %q: CONNECT needs a host and port", ErrInvalidTarget, target)
+		}
+		return Target{Form: AuthorityForm, Host: target, Query: url.Values{}}, nil
+	case target == "*":
+		if method != MethodOptions {
+			return Target{}, fmt.Errorf("%w %q: only OPTIONS applies to the whole server", ErrInvalidTarget, target)
+		}
+		return Target{Form: AsteriskForm, Path: "*", RawPath: "*", Query: url.Values{}}, nil
+	case strings.HasPrefix(target, "/"):
+		t := Target{Form: OriginForm}
+		err := t.parsePathAndQuery(target)
+		return t, err
+	}
+
+	scheme, rest, ok := strings.Cut(target, "://")
+	if !ok || !isScheme(scheme) {
+		return Target{}, fmt.Errorf("%w %q", ErrInvalidTarget, target)
+	}
+	host := rest
+	if i := strings.IndexAny(rest, "/?#"); i >= 0 {
+		host, rest = rest[:i], rest[i:]
+	} else {
+		rest = ""
+	}
+	if host == "" || strings.Contains(host, "@") {
+		// userinfo is deprecated in http URIs, RFC 9110 section 4.2.4
+		return Target{}, fmt.Errorf("%w %q: missing or invalid host", ErrInvalidTarget, target)
+	}
+	t := Target{Form: AbsoluteForm, Scheme: strings.ToLower(scheme), Host: host}
+	if !strings.HasPrefix(rest, "/") {
+		// an empty path stands for the root, RFC 9112 section 3.2.2
+		rest = "/" + rest
+	}
+	err := t.parsePathAndQuery(rest)
+	return t, err
+}
+
+// parsePathAndQuery fills in the path, query and fragment from s, which starts with the path.
+func (t *Target) parsePathAndQuery(s string) error {
+	s, fragment, _ := strings.Cut(s, "#")
+	rawPath, rawQuery, _ := strings.Cut(s, "?")
+	path, err := url.PathUnescape(rawPath)
+	if err != nil {
+		return fmt.Errorf("%w %q: %w", ErrInvalidTarget, s, err)
+	}
+	if _, err := url.QueryUnescape(rawQuery); err != nil {
+		return fmt.Errorf("%w %q: %w", ErrInvalidTarget, s, err)
+	}
+	// the only errors left are about semicolon separators, which just drop the parameter they are in
+	query, _ := url.ParseQuery(rawQuery)
+	if t.Fragment, err = url.PathUnescape(fragment); err != nil {
+		return fmt.Errorf("%w %q: %w", ErrInvalidTarget, s, err)
+	}
+	t.Path, t.RawPath, t.RawQuery, t.Query = path, rawPath, rawQuery, query
+	return nil
+}
+
+// isScheme reports whether s is a URI scheme, a letter followed by letters, digits, "+", "-" or ".".
+func isScheme(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		case i > 0 && (c >= '0' && c <= '9' || c == '+' || c == '-' || c == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,75 @@
+package request
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTarget(t *testing.T) {
+	// Test: Origin form with an escaped path and a query with repeated names
+	target, err := ParseTarget("GET", "/files/a%2Fb%20c?tag=x&tag=y%26z&empty=#top")
+	require.NoError(t, err)
+	assert.Equal(t, OriginForm, target.Form)
+	assert.Equal(t, "/files/a/b c", target.Path)
+	assert.Equal(t, "/files/a%2Fb%20c", target.RawPath)
+	assert.Equal(t, "tag=x&tag=y%26z&empty=", target.RawQuery)
+	assert.Equal(t, url.Values{"tag": {"x", "y&z"}, "empty": {""}}, target.Query)
+	assert.Equal(t, "top", target.Fragment)
+
+	// Test: Absolute form
+	target, err = ParseTarget("GET", "HTTP://example.com:8080?q=1")
+	require.NoError(t, err)
+	assert.Equal(t, AbsoluteForm, target.Form)
+	assert.Equal(t, "http", target.Scheme)
+	assert.Equal(t, "example.com:8080", target.Host)
+	assert.Equal(t, "/", target.Path)
+	assert.Equal(t, []string{"1"}, target.Query["q"])
+
+	// Test: Authority form for CONNECT
+	target, err = ParseTarget("CONNECT", "example.com:443")
+	require.NoError(t, err)
+	assert.Equal(t, AuthorityForm, target.Form)
+	assert.Equal(t, "example.com:443", target.Host)
+
+	// Test: Asterisk form for OPTIONS
+	target, err = ParseTarget("OPTIONS", "*")
+	require.NoError(t, err)
+	assert.Equal(t, AsteriskForm, target.Form)
+
+	// Test: Semicolons only drop their parameter
+	target, err = ParseTarget("GET", "/?a=1;b=2&c=3")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"3"}, target.Query["c"])
+
+	// Test: Malformed targets
+	for _, tc := range []struct{ method, target string }{
+		{"GET", "/bad%zzpath"},
+		{"GET", "/trailing%2"},
+		{"GET", "/?q=%G1"},
+		{"GET", "/ok?a=1;b=%"},
+		{"GET", "coffee"},
+		{"GET", "*"},
+		{"GET", "example.com:443"},
+		{"GET", "http:///nohost"},
+		{"GET", "http://user@example.com/"},
+		{"GET", "1http://example.com/"},
+		{"GET", "/tab\there"},
+		{"CONNECT", "/"},
+		{"CONNECT", "example.com"},
+	} {
+		_, err := ParseTarget(tc.method, tc.target)
+		assert.ErrorIs(t, err, ErrInvalidTarget, tc.method+" "+tc.target)
+	}
+
+	// Test: Target is parsed along with the request
+	r, err := RequestFromReader(strings.NewReader("GET /search?q=go+lang HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "/search", r.Target.Path)
+	assert.Equal(t, "go lang", r.Target.Query.Get("q"))
+	_, err = RequestFromReader(strings.NewReader("GET /%zz HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.ErrorIs(t, err, ErrInvalidTarget)
+}
@@ -0,0 +1,185 @@
+package response
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/TheBarnakhil/httpfromtcp/internal/headers"
+)
+
+// bodyBufferSize is how much body the writer holds back, hoping to send it with a Content-Length
+const bodyBufferSize = 4096
+
+/*
+Write writes p as part of the body and may be called any number of times.
+
+If the headers did not specify a Content-Length or a Transfer-Encoding, the writer
+picks the framing itself: the body is held back until it grows past bodyBufferSize,
+Flush is called or the response is finished. A body that is done by then is sent with
+a Content-Length, anything longer is sent with chunked encoding, or for HTTP/1.0 until
+the connection is closed. If the headers did specify a Content-Length, the body is cut
+off there and Write returns ErrContentLength.
+*/
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.writerState == StatusLineNext {
+		if err := w.commitLazily(p); err != nil {
+			return 0, err
+		}
+	}
+	if skip, err := w.skipBody(p); skip {
+		return 0, err
+	}
+	if w.writerState != BodyNext {
+		return 0, errors.New("error: add the status line then the headers and then the body")
+	}
+
+	switch {
+	case w.pending != nil:
+		w.buffered = append(w.buffered, p...)
+		w.bytesWritten += int64(len(p))
+		if len(w.buffered) > bodyBufferSize {
+			if err := w.startChunked(); err != nil {
+				return 0, err
+			}
+		}
+		return len(p), nil
+	case w.chunked:
+		if len(p) == 0 {
+			// an empty chunk would end the body
+			return 0, nil
+		}
+		if err := w.writeChunk(p); err != nil {
+			return 0, err
+		}
+		w.bytesWritten += int64(len(p))
+		return len(p), nil
+	default:
+		return w.sendBody(p)
+	}
+}
+
+/*
+sendBody sends p as a part of a body whose framing was decided already. A body with a
+Content-Length gets no more bytes than it announced, the connection would be out of step
+with the client otherwise, and writing past it is ErrContentLength.
+*/
+func (w *Writer) sendBody(p []byte) (int, error) {
+	var err error
+	if w.contentLength >= 0 && int64(len(p)) > w.contentLength-w.bytesWritten {
+		p = p[:max(w.contentLength-w.bytesWritten, 0)]
+		err = ErrContentLength
+	}
+	n, sendErr := w.send(p)
+	w.bytesWritten += int64(n)
+	if sendErr != nil {
+		return n, sendErr
+	}
+	return n, err
+}
+
+/*
+Flush sends everything written so far to the client. A body whose framing is
+still undecided is switched to chunked encoding, as its length is unknown, or
+for HTTP/1.0 to ending with the connection.
+If the underlying writer can be flushed as well, it is.
+*/
+func (w *Writer) Flush() error {
+	if w.writerState == StatusLineNext {
+		if err := w.commitLazily(nil); err != nil {
+			return err
+		}
+	}
+	if w.pending != nil && w.writerState == BodyNext {
+		if err := w.startChunked(); err != nil {
+			return err
+		}
+	}
+	if f, ok := w.writer.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+/*
+Finish completes a response the handler left open: a response that was not started
+is sent with an empty body, see WriteHeader, held back bodies are sent with a
+Content-Length and chunked bodies get their last chunk and an empty trailer section.
+The server calls it after the handler returned. If the body did not match the Content-Length
+the handler announced, the connection is marked to be closed.
+*/
+func (w *Writer) Finish() error {
+	switch w.writerState {
+	case StatusLineNext:
+		if err := w.commitLazily(nil); err != nil {
+			return err
+		}
+	case HeadersNext:
+		if err := w.WriteHeaders(headers.NewHeaders()); err != nil {
+			return err
+		}
+	}
+	switch w.writerState {
+	case BodyNext:
+		switch {
+		case w.pending != nil:
+			h := w.pending
+			w.pending = nil
+			h.Set("Content-Length", strconv.Itoa(len(w.buffered)))
+			if err := w.commitHeaders(h); err != nil {
+				return err
+			}
+			if _, err := w.send(w.buffered); err != nil {
+				return err
+			}
+			w.buffered = nil
+		case w.chunked:
+			if _, err := w.send([]byte("0" + crlf + crlf)); err != nil {
+				return err
+			}
+		case w.contentLength >= 0 && w.bytesWritten != w.contentLength && !w.head:
+			// a response to HEAD announces the length of a body it does not have
+			w.keepAlive = false
+		}
+		w.writerState = Done
+	case TrailersNext:
+		if !w.unchunked {
+			if _, err := w.send([]byte(crlf)); err != nil {
+				return err
+			}
+		}
+		w.writerState = Done
+	}
+	return nil
+}
+
+// startChunked sends the held back headers announcing a chunked body, followed by the held back body.
+func (w *Writer) startChunked() error {
+	h := w.pending
+	w.pending = nil
+	h.Set("Transfer-Encoding", "chunked")
+	if err := w.commitHeaders(h); err != nil {
+		return err
+	}
+	if len(w.buffered) != 0 {
+		if err := w.writeChunk(w.buffered); err != nil {
+			return err
+		}
+	}
+	w.buffered = nil
+	return nil
+}
+
+// writeChunk sends p as a single chunk with one write, or as is if the client cannot decode chunks.
+func (w *Writer) writeChunk(p []byte) error {
+	if w.unchunked {
+		_, err := w.send(p)
+		return err
+	}
+	buf := make([]byte, 0, len(p)+32)
+	buf = strconv.AppendInt(buf, int64(len(p)), 16)
+	buf = append(buf, crlf...)
+	buf = append(buf, p...)
+	buf = append(buf, crlf...)
+	_, err := w.send(buf)
+	return err
+}
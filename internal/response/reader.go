@@ -0,0 +1,342 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/TheBarnakhil/httpfromtcp/internal/framing"
+	"github.com/TheBarnakhil/httpfromtcp/internal/headers"
+)
+
+// Response is a response read by ResponseFromReader, the counterpart of request.Request.
+type Response struct {
+	StatusLine StatusLine
+	Headers    *headers.Headers
+	// BodyReader streams the body from the reader as it is read
+	BodyReader io.ReadCloser
+	// Body holds the whole body once ReadBody has been called
+	Body []byte
+	// Trailers holds the trailer fields sent after the last chunk of a chunked body,
+	// they are only available once the body has been read completely
+	Trailers *headers.Headers
+
+	// whether the connection can carry another exchange once the body was read
+	keepAlive bool
+}
+
+type StatusLine struct {
+	HttpVersion  string
+	StatusCode   StatusCode
+	ReasonPhrase string
+}
+
+const (
+	// maxHeaderBytes bounds the status line and header section of a response
+	maxHeaderBytes = 1 << 20
+	// maxHeaderCount bounds the number of header fields of a response
+	maxHeaderCount = 100
+	// readerBufferSize is the size of the buffered reader wrapped around reader, a single line must fit into it
+	readerBufferSize = 8192
+)
+
+/*
+ResponseFromReader parses a single response to a request with method from reader,
+including its whole body which is stored in Response.Body.
+*/
+func ResponseFromReader(reader io.Reader, method string) (*Response, error) {
+	resp, err := StreamingResponseFromReader(reader, method)
+	if err != nil {
+		return resp, err
+	}
+	if _, err := resp.ReadBody(); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+/*
+StreamingResponseFromReader parses the status line and headers of a response to a request
+with method from reader and leaves the body to be read from Response.BodyReader.
+
+The method matters as responses to HEAD never have a body, neither do 1xx, 204 and 304
+responses. Other bodies are delimited by chunked encoding or a Content-Length, following
+RFC 9112 section 6.3, and without either they last until reader is exhausted.
+An interim 1xx response is returned like any other, the final one follows it on reader.
+
+If reader is a *bufio.Reader, only the bytes of the response are consumed from it,
+so it can be passed in again for the next response on a persistent connection.
+It returns io.EOF if the reader is exhausted before any byte of a response was read.
+*/
+func StreamingResponseFromReader(reader io.Reader, method string) (*Response, error) {
+	br, ok := reader.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReaderSize(reader, readerBufferSize)
+	}
+
+	line, err := readLine(br)
+	if err != nil {
+		return nil, err
+	}
+	statusLine, err := parseStatusLine(line)
+	if err != nil {
+		return nil, fmt.Errorf("error: Unable to parse status line %w", err)
+	}
+	resp := &Response{
+		StatusLine: *statusLine,
+		Headers:    headers.NewHeaders(),
+		Trailers:   headers.NewHeaders(),
+	}
+	if err := readFields(br, resp.Headers, len(line)); err != nil {
+		return nil, err
+	}
+
+	body, err := resp.bodyReader(br, method)
+	if err != nil {
+		return nil, err
+	}
+	resp.BodyReader = NoBody
+	if body != nil {
+		resp.BodyReader = io.NopCloser(body)
+	}
+	return resp, nil
+}
+
+// ReadBody reads whatever is left of the body into r.Body and returns it.
+func (r *Response) ReadBody() ([]byte, error) {
+	if r.BodyReader == nil {
+		return r.Body, nil
+	}
+	data, err := io.ReadAll(r.BodyReader)
+	r.Body = append(r.Body, data...)
+	return r.Body, err
+}
+
+/*
+KeepAlive reports whether the connection the response was read from can carry another
+exchange once the body was read: the server did not ask to close it, and the body has
+a framing that can be trusted to end where the next response starts.
+*/
+func (r *Response) KeepAlive() bool {
+	return r.keepAlive
+}
+
+// parseStatusLine parses e.g. "HTTP/1.1 200 OK". The reason phrase may be empty.
+func parseStatusLine(line []byte) (*StatusLine, error) {
+	parts := strings.SplitN(string(line), " ", 3)
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("error: Malformed status line %q", line)
+	}
+	version, ok := strings.CutPrefix(parts[0], "HTTP/")
+	if !ok || (version != "1.1" && version != "1.0") {
+		return nil, fmt.Errorf("error: Unsupported HTTP version %q", parts[0])
+	}
+	code, err := strconv.Atoi(parts[1])
+	if err != nil || len(parts[1]) != 3 || code < 100 {
+		return nil, fmt.Errorf("error: Invalid status code %q", parts[1])
+	}
+	statusLine := &StatusLine{HttpVersion: version, StatusCode: StatusCode(code)}
+	if len(parts) == 3 {
+		if strings.ContainsRune(parts[2], '\r') {
+			return nil, errors.New("error: Invalid reason phrase")
+		}
+		statusLine.ReasonPhrase = parts[2]
+	}
+	return statusLine, nil
+}
+
+// readFields reads header lines into h up to and including the empty line that ends them.
+func readFields(br *bufio.Reader, h *headers.Headers, read int) error {
+	for {
+		line, err := readLine(br)
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		read += len(line) + len(crlf)
+		if read > maxHeaderBytes {
+			return errors.New("error: Response headers too large")
+		}
+		_, done, err := h.Parse(append(line, crlf...))
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+		if h.Len() > maxHeaderCount {
+			return errors.New("error: Response headers too large")
+		}
+	}
+}
+
+// bodyReader returns a reader for the body, delimited the way the status and the headers say, or nil if there is no body.
+func (r *Response) bodyReader(br *bufio.Reader, method string) (io.Reader, error) {
+	r.keepAlive = !r.Headers.HasToken("Connection", "close")
+	if r.StatusLine.HttpVersion == "1.0" {
+		r.keepAlive = r.Headers.HasToken("Connection", "keep-alive")
+	}
+	if method == "HEAD" || !bodyAllowed(r.StatusLine.StatusCode) {
+		return nil, nil
+	}
+
+	if te, ok := r.Headers.Get("Transfer-Encoding"); ok {
+		codings := strings.Split(te, ",")
+		if !strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked") {
+			r.keepAlive = false
+			return br, nil
+		}
+		if r.Headers.Has("Content-Length") {
+			// the Content-Length is ignored, and a connection carrying both is not trusted any further
+			r.keepAlive = false
+		}
+		return &chunkedReader{br: br, trailers: r.Trailers}, nil
+	}
+
+	if values := r.Headers.Values("Content-Length"); len(values) != 0 {
+		n, err := framing.ParseContentLength(values)
+		if err != nil {
+			return nil, fmt.Errorf("error: Invalid response framing: %w", err)
+		}
+		if n == 0 {
+			return nil, nil
+		}
+		return &lengthReader{br: br, remaining: n}, nil
+	}
+	r.keepAlive = false
+	return br, nil
+}
+
+// readLine reads a line ending in CRLF and returns it without the CRLF.
+func readLine(br *bufio.Reader) ([]byte, error) {
+	line, err := br.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return nil, errors.New("error: Response line too long")
+	}
+	if err != nil {
+		if errors.Is(err, io.EOF) && len(line) != 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if !bytes.HasSuffix(line, []byte(crlf)) {
+		return nil, errors.New("error: Response line does not end in CRLF")
+	}
+	// ReadSlice's buffer is overwritten by the next read
+	return bytes.Clone(line[:len(line)-len(crlf)]), nil
+}
+
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// lengthReader reads a body delimited by a Content-Length.
+type lengthReader struct {
+	br        *bufio.Reader
+	remaining int64
+}
+
+func (l *lengthReader) Read(p []byte) (int, error) {
+	if l.remaining == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.br.Read(p)
+	l.remaining -= int64(n)
+	if errors.Is(err, io.EOF) {
+		return n, io.ErrUnexpectedEOF
+	}
+	if err == nil && l.remaining == 0 {
+		// say so right away, so a connection can be released without another read
+		err = io.EOF
+	}
+	return n, err
+}
+
+// chunkedReader reads a chunked body, storing its trailer fields once it gets to them.
+type chunkedReader struct {
+	br       *bufio.Reader
+	trailers *headers.Headers
+	// bytes left in the current chunk
+	remaining int64
+	// whether the CRLF ending the previous chunk has yet to be read
+	chunkEnd bool
+	done     bool
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.done {
+		return 0, io.EOF
+	}
+	if c.remaining == 0 {
+		if err := c.nextChunk(); err != nil {
+			return 0, err
+		}
+		if c.done {
+			return 0, io.EOF
+		}
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.br.Read(p)
+	c.remaining -= int64(n)
+	if c.remaining == 0 {
+		c.chunkEnd = true
+	}
+	if errors.Is(err, io.EOF) {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// nextChunk reads up to the data of the next chunk, or through the trailers after the last one.
+func (c *chunkedReader) nextChunk() error {
+	if c.chunkEnd {
+		line, err := readLine(c.br)
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		if len(line) != 0 {
+			return errors.New("error: Chunk data is longer than its size")
+		}
+		c.chunkEnd = false
+	}
+	line, err := readLine(c.br)
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	n, err := framing.ParseChunkSize(line)
+	if err != nil {
+		return fmt.Errorf("error: Invalid response framing: %w", err)
+	}
+	if n == 0 {
+		if err := readFields(c.br, c.trailers, 0); err != nil {
+			return err
+		}
+		c.done = true
+		return nil
+	}
+	c.remaining = n
+	return nil
+}
+
+// NoBody is the BodyReader of a response that has no body, reading from it returns io.EOF right away.
+var NoBody io.ReadCloser = noBody{}
+
+type noBody struct{}
+
+func (noBody) Read([]byte) (int, error) { return 0, io.EOF }
+
+func (noBody) Close() error { return nil }
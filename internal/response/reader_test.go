@@ -0,0 +1,145 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/TheBarnakhil/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseFromReader(t *testing.T) {
+	// Test: Body delimited by Content-Length
+	resp, err := ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 5\r\nContent-Type: text/plain\r\n\r\nhello"), "GET")
+	require.NoError(t, err)
+	assert.Equal(t, StatusLine{HttpVersion: "1.1", StatusCode: OK, ReasonPhrase: "OK"}, resp.StatusLine)
+	contentType, _ := resp.Headers.Get("Content-Type")
+	assert.Equal(t, "text/plain", contentType)
+	assert.Equal(t, "hello", string(resp.Body))
+	assert.True(t, resp.KeepAlive())
+
+	// Test: Empty reason phrase
+	resp, err = ResponseFromReader(strings.NewReader("HTTP/1.1 599 \r\nContent-Length: 0\r\n\r\n"), "GET")
+	require.NoError(t, err)
+	assert.Equal(t, StatusCode(599), resp.StatusLine.StatusCode)
+	assert.Equal(t, "", resp.StatusLine.ReasonPhrase)
+
+	// Test: Chunked body with trailers
+	resp, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\n"+
+		"Transfer-Encoding: chunked\r\n"+
+		"Trailer: X-Content-SHA256\r\n"+
+		"\r\n"+
+		"5;ext=1\r\nhello\r\n"+
+		"6\r\n world\r\n"+
+		"0\r\n"+
+		"X-Content-SHA256: abc\r\n"+
+		"\r\n"), "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(resp.Body))
+	sum, _ := resp.Trailers.Get("X-Content-SHA256")
+	assert.Equal(t, "abc", sum)
+
+	// Test: Body without framing lasts until the end of the reader
+	resp, err = ResponseFromReader(strings.NewReader("HTTP/1.0 200 OK\r\n\r\nuntil the end"), "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "until the end", string(resp.Body))
+	assert.False(t, resp.KeepAlive())
+
+	// Test: Responses without a body
+	for _, tc := range []struct{ raw, method string }{
+		{"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n", "HEAD"},
+		{"HTTP/1.1 204 No Content\r\n\r\n", "GET"},
+		{"HTTP/1.1 304 Not Modified\r\nContent-Length: 5\r\n\r\n", "GET"},
+		{"HTTP/1.1 100 Continue\r\n\r\n", "GET"},
+	} {
+		resp, err = StreamingResponseFromReader(strings.NewReader(tc.raw+"HTTP/1.1 200 OK\r\n\r\n"), tc.method)
+		require.NoError(t, err)
+		assert.Equal(t, NoBody, resp.BodyReader, tc.raw)
+		assert.True(t, resp.KeepAlive())
+	}
+
+	// Test: Exhausted reader
+	_, err = StreamingResponseFromReader(strings.NewReader(""), "GET")
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestPersistentResponses(t *testing.T) {
+	// Test: Responses written by Writer are read back one after another from the same reader
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetKeepAlive(true)
+	w.Header().Set("Content-Type", "text/plain")
+	_, err := w.Write([]byte("first"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+
+	w = NewWriter(&buf)
+	w.SetKeepAlive(true)
+	require.NoError(t, w.WriteStatusLine(OK))
+	h := headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Trailer", "X-Content-Length")
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteChunkedBody([]byte("second"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Content-Length", "6")
+	require.NoError(t, w.WriteTrailers(trailers))
+
+	br := bufio.NewReader(&buf)
+	resp, err := ResponseFromReader(br, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "first", string(resp.Body))
+	resp, err = ResponseFromReader(br, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(resp.Body))
+	length, _ := resp.Trailers.Get("X-Content-Length")
+	assert.Equal(t, "6", length)
+	_, err = ResponseFromReader(br, "GET")
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestMalformedResponse(t *testing.T) {
+	for _, raw := range []string{
+		"HTTP/2 200 OK\r\n\r\n",
+		"HTTP/1.1 20 OK\r\n\r\n",
+		"HTTP/1.1 OK\r\n\r\n",
+		"HTTP/1.1 200 OK\n\n",
+		"HTTP/1.1 200 OK\r\nContent-Length: 5\r\nContent-Length: 6\r\n\r\nhello",
+		"HTTP/1.1 200 OK\r\nContent-Length: -1\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Length: +5\r\n\r\nhello",
+		"HTTP/1.1 200 OK\r\nBad Header: x\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n",
+	} {
+		_, err := StreamingResponseFromReader(strings.NewReader(raw), "GET")
+		assert.Error(t, err, raw)
+	}
+
+	// Test: Truncated or malformed bodies
+	for _, raw := range []string{
+		"HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nhello",
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhel",
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n",
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n 5\r\nhello\r\n0\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n-5\r\nhello\r\n0\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nhello\r\n0\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n",
+	} {
+		_, err := ResponseFromReader(strings.NewReader(raw), "GET")
+		assert.Error(t, err, raw)
+	}
+
+	// Test: Header count limit, the empty line ending the headers does not count
+	fields := strings.Repeat("X-Field: x\r\n", maxHeaderCount)
+	_, err := ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\n"+fields+"Content-Length: 0\r\n\r\n"), "GET")
+	require.Error(t, err)
+	resp, err := ResponseFromReader(strings.NewReader("HTTP/1.1 204 No Content\r\n"+fields+"\r\n"), "GET")
+	require.NoError(t, err)
+	assert.Equal(t, maxHeaderCount, resp.Headers.Len())
+}
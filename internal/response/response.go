@@ -4,13 +4,21 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/TheBarnakhil/httpfromtcp/internal/headers"
 )
 
 type StatusCode int
 
+const crlf = "\r\n"
+
+// TimeFormat is the format of the Date header.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
 type WriterState int
 
 type ContentType string
@@ -32,6 +40,30 @@ const (
 type Writer struct {
 	writerState WriterState
 	writer      io.Writer
+	keepAlive   bool
+	header      *headers.Headers
+	// head is set for a response to HEAD, discard once its headers are out
+	head    bool
+	discard bool
+	// http10 is set for a response to an HTTP/1.0 request, see SetVersion
+	http10 bool
+
+	// what has been written so far, for middleware to observe
+	statusCode   StatusCode
+	bytesWritten int64
+
+	// lazy commit, see WriteHeader
+	nextStatus    StatusCode
+	statusPending bool
+	sent          bool
+
+	// body framing, see Write
+	pending       *headers.Headers
+	buffered      []byte
+	chunked       bool
+	contentLength int64
+	// unchunked is set when chunked encoding was asked for but the client cannot decode it
+	unchunked bool
 }
 
 func NewWriter(w io.Writer) *Writer {
@@ -41,133 +73,461 @@ func NewWriter(w io.Writer) *Writer {
 	}
 }
 
-const (
-	OK          StatusCode = 200
-	BadRequest  StatusCode = 400
-	ServerError StatusCode = 500
-)
+// ErrBodyNotAllowed is returned when writing a body for a 1xx, 204 or 304 response.
+var ErrBodyNotAllowed = errors.New("error: Response status does not allow a body")
 
+// ErrContentLength is returned when writing more body than the Content-Length of the response announced.
+var ErrContentLength = errors.New("error: Response body is longer than its Content-Length")
+
+// WriteStatusLine writes the status line with the standard reason phrase of statusCode.
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
+	return w.WriteStatusLineWithReason(statusCode, reasonPhrases[statusCode])
+}
+
+/*
+WriteStatusLineWithReason writes the status line with a custom reason phrase.
+Any three digit status code is accepted and the reason phrase may be empty.
+*/
+func (w *Writer) WriteStatusLineWithReason(statusCode StatusCode, reason string) error {
 	if w.writerState != StatusLineNext {
 		return errors.New("error: add the status line then the headers and then the body")
 	}
+	if statusCode < 100 || statusCode > 999 {
+		return errors.New("error: Invalid status code")
+	}
+	if strings.ContainsAny(reason, "\r\n") {
+		return errors.New("error: Invalid reason phrase")
+	}
 
-	switch statusCode {
-	case OK:
-		_, err := w.writer.Write([]byte("HTTP/1.1 200 OK\r\n"))
-		if err != nil {
-			return err
-		}
-		w.writerState = HeadersNext
-		return nil
-	case BadRequest:
-		_, err := w.writer.Write([]byte("HTTP/1.1 400 Bad Request\r\n"))
-		if err != nil {
-			return err
+	_, err := w.send([]byte(w.statusLine(statusCode, reason)))
+	if err != nil {
+		return err
+	}
+	w.statusCode = statusCode
+	w.writerState = HeadersNext
+	return nil
+}
+
+func (w *Writer) statusLine(statusCode StatusCode, reason string) string {
+	version := "1.1"
+	if w.http10 {
+		version = "1.0"
+	}
+	return fmt.Sprintf("HTTP/%s %d %s\r\n", version, statusCode, reason)
+}
+
+/*
+WriteHeader sets the status code of the response without writing anything yet.
+The status line is sent together with the headers from Header when the body is first
+written or the response is finished, so headers can be changed until then.
+A 1xx status is an interim response and is sent right away, unless the client speaks
+HTTP/1.0, which has none. Only the first call counts, and none does once the status
+line was written.
+*/
+func (w *Writer) WriteHeader(statusCode StatusCode) {
+	if w.writerState != StatusLineNext || w.nextStatus != 0 {
+		return
+	}
+	if statusCode >= 100 && statusCode < 200 {
+		if w.http10 {
+			return
 		}
-		w.writerState = HeadersNext
-		return nil
-	case ServerError:
-		_, err := w.writer.Write([]byte("HTTP/1.1 500 Internal Server Error\r\n"))
-		if err != nil {
-			return err
+		if w.WriteStatusLine(statusCode) == nil {
+			w.WriteHeaders(headers.NewHeaders())
 		}
-		w.writerState = HeadersNext
-		return nil
-	default:
+		return
+	}
+	w.nextStatus = statusCode
+}
+
+/*
+commitLazily starts a response the handler did not start with WriteStatusLine.
+The status is the one given to WriteHeader, 200 if there was none, and the headers
+are those from Header plus a Date and, sniffed from p, a Content-Type.
+*/
+func (w *Writer) commitLazily(p []byte) error {
+	statusCode := w.nextStatus
+	if statusCode == 0 {
+		statusCode = OK
+	}
+	if statusCode < 100 || statusCode > 999 {
 		return errors.New("error: Invalid status code")
 	}
+	w.statusCode = statusCode
+	w.statusPending = true
+	w.writerState = HeadersNext
+
+	h := w.Header()
+	if !h.Has("Date") {
+		h.Set("Date", time.Now().UTC().Format(TimeFormat))
+	}
+	if !h.Has("Content-Type") && len(p) != 0 && bodyAllowed(statusCode) {
+		h.Set("Content-Type", http.DetectContentType(p))
+	}
+	return w.WriteHeaders(h)
 }
 
-func GetDefaultHeaders(contentLen int, contentType ContentType) headers.Headers {
+/*
+Reset throws away a response that has not reached the client yet, including the
+headers set through Header, so that another one can be written instead.
+It returns false if anything was sent already.
+*/
+func (w *Writer) Reset() bool {
+	if w.sent {
+		return false
+	}
+	*w = Writer{
+		writerState: StatusLineNext,
+		writer:      w.writer,
+		keepAlive:   w.keepAlive,
+		head:        w.head,
+		http10:      w.http10,
+	}
+	return true
+}
+
+/*
+Abort gives up on a response that cannot be completed, e.g. because the body it relays
+broke off. Nothing more is written, not even by Finish, and the connection is to be
+closed, so the client can tell the response is incomplete.
+*/
+func (w *Writer) Abort() {
+	w.keepAlive = false
+	w.pending = nil
+	w.buffered = nil
+	w.writerState = Done
+}
+
+// send writes p to the connection, noting that the response has started.
+func (w *Writer) send(p []byte) (int, error) {
+	w.sent = true
+	if w.discard {
+		return len(p), nil
+	}
+	return w.writer.Write(p)
+}
+
+// State returns which part of the response the writer expects next.
+func (w *Writer) State() WriterState {
+	return w.writerState
+}
+
+/*
+StatusCode returns the status code of the response. Until the status line is written,
+it is the one given to WriteHeader, or 200, which a response that was not started is sent with.
+*/
+func (w *Writer) StatusCode() StatusCode {
+	switch {
+	case w.statusCode != 0:
+		return w.statusCode
+	case w.nextStatus != 0:
+		return w.nextStatus
+	}
+	return OK
+}
+
+// BytesWritten returns how many body bytes were written, not counting chunk framing.
+func (w *Writer) BytesWritten() int64 {
+	return w.bytesWritten
+}
+
+/*
+Header returns the headers of the response. They can be changed until the headers are written.
+A handler that does not call WriteHeaders gets exactly these, see WriteHeader; otherwise they are
+sent along with the ones passed to WriteHeaders, unless those already contain the same key.
+*/
+func (w *Writer) Header() *headers.Headers {
+	if w.header == nil {
+		w.header = headers.NewHeaders()
+	}
+	return w.header
+}
+
+func GetDefaultHeaders(contentLen int, contentType ContentType) *headers.Headers {
 	h := headers.NewHeaders()
-	h["Content-Length"] = strconv.Itoa(contentLen)
-	h["Connection"] = "close"
+	h.Set("Content-Length", strconv.Itoa(contentLen))
 	if contentType != "" {
-		h["Content-Type"] = string(contentType)
+		h.Set("Content-Type", string(contentType))
 	} else {
-		h["Content-Type"] = "text/plain"
+		h.Set("Content-Type", "text/plain")
 	}
 	return h
 }
 
-func (w *Writer) WriteHeaders(headers headers.Headers) error {
+/*
+SetKeepAlive tells the writer whether the connection is meant to stay open after this response.
+When it is false, which is the default, WriteHeaders adds a "Connection: close" header.
+*/
+func (w *Writer) SetKeepAlive(keepAlive bool) {
+	w.keepAlive = keepAlive
+}
+
+/*
+SetHead tells the writer that the response answers a HEAD request. It is written
+like the response to a GET, Content-Length included, but the body is not sent.
+*/
+func (w *Writer) SetHead(head bool) {
+	w.head = head
+}
+
+/*
+SetVersion tells the writer the HTTP version of the request it answers. The response
+to an HTTP/1.0 request says so in its status line and cannot use chunked encoding,
+a body meant to be chunked is sent as is and ends when the connection is closed.
+Any other version is answered with HTTP/1.1, the default.
+*/
+func (w *Writer) SetVersion(version string) {
+	w.http10 = version == "1.0"
+}
+
+/*
+KeepAlive reports whether the connection can be reused for another request.
+That is only the case if the response was written completely and neither the
+server nor the handler asked for the connection to be closed.
+*/
+func (w *Writer) KeepAlive() bool {
+	return w.keepAlive && w.writerState == Done
+}
+
+/*
+WriteHeaders writes headers, together with those added through Header, in the order they were added.
+Every name is written in canonical form, e.g. "content-type" as "Content-Type".
+The fields are copied, headers itself is left as it is and can be reused for other responses.
+*/
+func (w *Writer) WriteHeaders(headers *headers.Headers) error {
 	if w.writerState != HeadersNext {
 		return errors.New("error: add the status line then the headers and then the body")
 	}
-	headerStr := ""
-	for key, val := range headers {
-		headerStr += fmt.Sprintf("%s: %s\r\n", key, val)
+	h := headers.Clone()
+	for key, val := range w.header.All() {
+		if !headers.Has(key) {
+			h.Add(key, val)
+		}
+	}
+	if err := validateFields(h); err != nil {
+		return err
 	}
-	_, err := w.writer.Write([]byte(headerStr + "\r\n"))
+	if !bodyAllowed(w.statusCode) {
+		// there is no body to frame, a 304 keeps its Content-Length as it describes the selected representation
+		h.Del("Transfer-Encoding")
+		if w.statusCode != NotModified {
+			h.Del("Content-Length")
+		}
+	} else if !h.Has("Content-Length") && !h.Has("Transfer-Encoding") {
+		// the framing is chosen once it is known how long the body gets, see Write
+		w.pending = h
+		w.writerState = BodyNext
+		return nil
+	}
+	return w.commitHeaders(h)
+}
+
+// commitHeaders sends the header section and moves on to whatever may follow it.
+func (w *Writer) commitHeaders(headers *headers.Headers) error {
+	informational := w.statusCode < 200
+	te, _ := headers.Get("Transfer-Encoding")
+	w.chunked = strings.EqualFold(te, "chunked")
+	if w.chunked && w.http10 {
+		// HTTP/1.0 has no chunked encoding, nor trailers, the body is delimited by closing the connection
+		headers.Del("Transfer-Encoding")
+		headers.Del("Trailer")
+		w.chunked = false
+		w.unchunked = true
+	}
+	if !informational {
+		w.setConnectionHeader(headers)
+	}
+	w.contentLength = -1
+	if cl, ok := headers.Get("Content-Length"); ok {
+		if n, err := strconv.ParseInt(cl, 10, 64); err == nil {
+			w.contentLength = n
+		}
+	}
+
+	var buf []byte
+	if w.statusPending {
+		w.statusPending = false
+		buf = append(buf, w.statusLine(w.statusCode, reasonPhrases[w.statusCode])...)
+	}
+	buf = appendFields(buf, headers)
+	_, err := w.send(append(buf, crlf...))
 	if err != nil {
 		return err
 	}
-	w.writerState = BodyNext
+	// the body and trailers of a response to HEAD are left out
+	w.discard = w.head && !informational
+
+	switch {
+	case w.statusCode == SwitchingProtocols:
+		// the connection now speaks another protocol
+		w.keepAlive = false
+		w.writerState = Done
+	case informational:
+		// an interim response, the final one follows
+		w.writerState = StatusLineNext
+	case !bodyAllowed(w.statusCode):
+		w.writerState = Done
+	default:
+		w.writerState = BodyNext
+	}
 	return nil
 }
 
+/*
+skipBody reports whether a body write has to be skipped because the response cannot have a body.
+Empty writes are skipped silently, anything else is ErrBodyNotAllowed.
+*/
+func (w *Writer) skipBody(p []byte) (bool, error) {
+	if w.writerState != Done || w.statusCode == 0 || bodyAllowed(w.statusCode) {
+		return false, nil
+	}
+	if len(p) != 0 {
+		return true, ErrBodyNotAllowed
+	}
+	return true, nil
+}
+
 func (w *Writer) WriteBody(p []byte) (int, error) {
+	if w.writerState == StatusLineNext {
+		if err := w.commitLazily(p); err != nil {
+			return 0, err
+		}
+	}
+	if skip, err := w.skipBody(p); skip {
+		return 0, err
+	}
 	if w.writerState != BodyNext {
 		return 0, errors.New("error: add the status line then the headers and then the body")
 	}
-	n, err := w.writer.Write(p)
-	if err != nil {
+	if w.pending != nil {
+		// the whole body is known now, so it can be sent with a Content-Length
+		w.buffered = append(w.buffered, p...)
+		w.bytesWritten += int64(len(p))
+		return len(p), w.Finish()
+	}
+	n, err := w.sendBody(p)
+	if err != nil && !errors.Is(err, ErrContentLength) {
 		return 0, err
 	}
 	w.writerState = Done
-	return n, nil
+	return n, err
 }
 
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
-	if w.writerState != BodyNext {
-		return 0, errors.New("error: add the status line then the headers and then the body")
+	if w.writerState == StatusLineNext {
+		if err := w.commitLazily(p); err != nil {
+			return 0, err
+		}
 	}
-	n1, err := w.writer.Write([]byte(strconv.FormatInt(int64(len(p)), 16) + "\r\n"))
-	if err != nil {
+	if skip, err := w.skipBody(p); skip {
 		return 0, err
 	}
-	n2, err := w.writer.Write([]byte(p))
-	if err != nil {
-		return n1, err
+	if w.writerState != BodyNext {
+		return 0, errors.New("error: add the status line then the headers and then the body")
 	}
-	n3, err := w.writer.Write([]byte("\r\n"))
-	if err != nil {
-		return n1 + n2, err
+	if w.pending != nil {
+		if err := w.startChunked(); err != nil {
+			return 0, err
+		}
 	}
-	return n1 + n2 + n3, nil
+	if err := w.writeChunk(p); err != nil {
+		return 0, err
+	}
+	w.bytesWritten += int64(len(p))
+	return len(strconv.FormatInt(int64(len(p)), 16)) + len(p) + 2*len(crlf), nil
 }
 
 func (w *Writer) WriteChunkedBodyDone() (int, error) {
+	if w.writerState == StatusLineNext {
+		if err := w.commitLazily(nil); err != nil {
+			return 0, err
+		}
+	}
+	if skip, err := w.skipBody(nil); skip {
+		return 0, err
+	}
 	if w.writerState != BodyNext {
 		return 0, errors.New("error: add the status line then the headers and then the body")
 	}
-	n, err := w.writer.Write([]byte("0" + "\r\n"))
-	if err != nil {
-		return 0, err
+	if w.pending == nil && !w.chunked && !w.unchunked {
+		return 0, errors.New("error: the body is not chunked")
+	}
+	if w.pending != nil {
+		if err := w.startChunked(); err != nil {
+			return 0, err
+		}
+	}
+	n := 0
+	if !w.unchunked {
+		var err error
+		if n, err = w.send([]byte("0" + "\r\n")); err != nil {
+			return 0, err
+		}
 	}
 	w.writerState = TrailersNext
 	return n, nil
 }
 
-func (w *Writer) WriteTrailers(h headers.Headers) error {
+func (w *Writer) WriteTrailers(h *headers.Headers) error {
+	if skip, err := w.skipBody(nil); skip {
+		return err
+	}
 	if w.writerState != TrailersNext {
 		return errors.New("error: add the status line then the headers and then the body")
 	}
-	if len(h) != 0 {
-		headerStr := ""
-		for key, val := range h {
-			headerStr += fmt.Sprintf("%s: %s\r\n", key, val)
-		}
-		_, err := w.writer.Write([]byte(headerStr))
-		if err != nil {
-			return err
-		}
+	if err := validateFields(h); err != nil {
+		return err
+	}
+	if w.unchunked {
+		// trailers cannot follow a body that is not chunked
+		w.writerState = Done
+		return nil
 	}
-	_, err := w.writer.Write([]byte("\r\n"))
+	_, err := w.send(append(appendFields(nil, h), crlf...))
 	if err != nil {
 		return err
 	}
 	w.writerState = Done
 	return nil
 }
+
+// validateFields rejects names or values containing a line break, as they would end the header section early.
+func validateFields(h *headers.Headers) error {
+	for key, val := range h.All() {
+		if strings.ContainsAny(key, "\r\n") || strings.ContainsAny(val, "\r\n") {
+			return fmt.Errorf("error: Invalid header %q, it contains a line break", key)
+		}
+	}
+	return nil
+}
+
+// appendFields appends the header lines of h to buf in insertion order, with canonical names.
+func appendFields(buf []byte, h *headers.Headers) []byte {
+	for key, val := range h.All() {
+		buf = append(buf, headers.CanonicalKey(key)...)
+		buf = append(buf, ": "...)
+		buf = append(buf, val...)
+		buf = append(buf, crlf...)
+	}
+	return buf
+}
+
+/*
+setConnectionHeader decides if the connection survives this response and makes the headers say so.
+A response without a Content-Length that is not chunked can only be delimited by closing the connection.
+HTTP/1.0 clients expect the connection to be closed unless they are told otherwise.
+*/
+func (w *Writer) setConnectionHeader(h *headers.Headers) {
+	hasLength := h.Has("Content-Length")
+	te, _ := h.Get("Transfer-Encoding")
+	conn, _ := h.Get("Connection")
+	framed := hasLength || strings.EqualFold(te, "chunked") || !bodyAllowed(w.statusCode)
+	if !framed || strings.EqualFold(conn, "close") {
+		w.keepAlive = false
+	}
+	if !w.keepAlive {
+		h.Set("Connection", "close")
+	} else if w.http10 {
+		h.Set("Connection", "keep-alive")
+	}
+}
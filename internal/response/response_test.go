@@ -0,0 +1,404 @@
+package response
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/TheBarnakhil/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusLine(t *testing.T) {
+	// Test: Standard reason phrase
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(TooManyRequests))
+	assert.Equal(t, "HTTP/1.1 429 Too Many Requests\r\n", buf.String())
+	assert.Equal(t, TooManyRequests, w.StatusCode())
+
+	// Test: Unregistered code without a reason phrase
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(599))
+	assert.Equal(t, "HTTP/1.1 599 \r\n", buf.String())
+
+	// Test: Custom reason phrase
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLineWithReason(OK, "All Good"))
+	assert.Equal(t, "HTTP/1.1 200 All Good\r\n", buf.String())
+
+	// Test: Invalid status code
+	w = NewWriter(&buf)
+	require.Error(t, w.WriteStatusLine(42))
+	require.Error(t, w.WriteStatusLine(1000))
+
+	// Test: Reason phrase with a line break
+	w = NewWriter(&buf)
+	require.Error(t, w.WriteStatusLineWithReason(OK, "OK\r\nX-Injected: yes"))
+}
+
+func TestNoBodyStatus(t *testing.T) {
+	// Test: 204 drops framing headers and refuses a body
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetKeepAlive(true)
+	require.NoError(t, w.WriteStatusLine(NoContent))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0, Plain)))
+	assert.Equal(t, "HTTP/1.1 204 No Content\r\nContent-Type: text/plain\r\n\r\n", buf.String())
+	_, err := w.WriteBody(nil)
+	require.NoError(t, err)
+	_, err = w.WriteBody([]byte("body"))
+	require.ErrorIs(t, err, ErrBodyNotAllowed)
+	assert.True(t, w.KeepAlive())
+
+	// Test: 304 keeps its Content-Length
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetKeepAlive(true)
+	require.NoError(t, w.WriteStatusLine(NotModified))
+	h := headers.NewHeaders()
+	h.Set("Content-Length", "10")
+	require.NoError(t, w.WriteHeaders(h))
+	assert.Equal(t, "HTTP/1.1 304 Not Modified\r\nContent-Length: 10\r\n\r\n", buf.String())
+	assert.True(t, w.KeepAlive())
+
+	// Test: Response to HEAD gets the Content-Length of its body, but not the body
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetKeepAlive(true)
+	w.SetHead(true)
+	w.Header().Set("Content-Type", "text/plain")
+	_, err = w.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasSuffix(buf.String(), "Content-Length: 5\r\n\r\n"))
+	assert.Equal(t, int64(5), w.BytesWritten())
+	assert.True(t, w.KeepAlive())
+
+	// Test: Response to HEAD with a Content-Length and no body written stays alive
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetKeepAlive(true)
+	w.SetHead(true)
+	w.Header().Set("Content-Length", "10")
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n"))
+	assert.NotContains(t, buf.String(), "Connection: close")
+	assert.True(t, w.KeepAlive())
+
+	// Test: Interim 1xx response is followed by the final one
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(Continue))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	require.NoError(t, w.WriteStatusLine(OK))
+	assert.Equal(t, "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 200 OK\r\n", buf.String())
+}
+
+func TestHeaderSerialization(t *testing.T) {
+	// Test: Headers are written in insertion order with canonical names
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetKeepAlive(true)
+	w.Header().Set("x-request-id", "abc")
+	h := GetDefaultHeaders(2, HTML)
+	h.Add("set-cookie", "a=1")
+	h.Add("SET-COOKIE", "b=2")
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err := w.WriteBody([]byte("ok"))
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Content-Length: 2\r\n"+
+		"Content-Type: text/html\r\n"+
+		"Set-Cookie: a=1\r\n"+
+		"Set-Cookie: b=2\r\n"+
+		"X-Request-Id: abc\r\n"+
+		"\r\n"+
+		"ok", buf.String())
+
+	// Test: Trailers in insertion order
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(OK))
+	h = headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Trailer", "X-B, X-A")
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	trailers := headers.NewHeaders()
+	trailers.Set("x-b", "2")
+	trailers.Set("x-a", "1")
+	require.NoError(t, w.WriteTrailers(trailers))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Transfer-Encoding: chunked\r\n"+
+		"Trailer: X-B, X-A\r\n"+
+		"Connection: close\r\n"+
+		"\r\n"+
+		"0\r\n"+
+		"X-B: 2\r\n"+
+		"X-A: 1\r\n"+
+		"\r\n", buf.String())
+
+	// Test: Headers passed in are left as they are and can be reused
+	shared := GetDefaultHeaders(0, Plain)
+	before := shared.Clone()
+	for _, statusCode := range []StatusCode{NoContent, OK} {
+		buf.Reset()
+		w = NewWriter(&buf)
+		w.Header().Set("X-Request-Id", "abc")
+		require.NoError(t, w.WriteStatusLine(statusCode))
+		require.NoError(t, w.WriteHeaders(shared))
+		_, err = w.WriteBody(nil)
+		require.NoError(t, err)
+		assert.Equal(t, before, shared)
+	}
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Content-Length: 0\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"X-Request-Id: abc\r\n"+
+		"Connection: close\r\n"+
+		"\r\n", buf.String())
+
+	// Test: Header value with a line break is rejected
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(OK))
+	h = headers.NewHeaders()
+	h.Set("Location", "/\r\nX-Injected: yes")
+	require.Error(t, w.WriteHeaders(h))
+}
+
+func TestAutomaticFraming(t *testing.T) {
+	// Test: Small body written in pieces gets a Content-Length
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetKeepAlive(true)
+	require.NoError(t, w.WriteStatusLine(OK))
+	h := headers.NewHeaders()
+	h.Set("Content-Type", "text/plain")
+	require.NoError(t, w.WriteHeaders(h))
+	_, err := w.Write([]byte("hello "))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("world"))
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", buf.String())
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"Content-Length: 11\r\n"+
+		"\r\n"+
+		"hello world", buf.String())
+	assert.True(t, w.KeepAlive())
+	assert.Equal(t, int64(11), w.BytesWritten())
+
+	// Test: Body growing past the buffer switches to chunked encoding
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetKeepAlive(true)
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	big := strings.Repeat("a", bodyBufferSize+1)
+	_, err = w.Write([]byte(big))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("b"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Transfer-Encoding: chunked\r\n"+
+		"\r\n"+
+		"1001\r\n"+big+"\r\n"+
+		"1\r\nb\r\n"+
+		"0\r\n\r\n", buf.String())
+	assert.True(t, w.KeepAlive())
+
+	// Test: Flush sends the headers and what was written so far
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	_, err = w.Write([]byte("tick"))
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Transfer-Encoding: chunked\r\n"+
+		"Connection: close\r\n"+
+		"\r\n"+
+		"4\r\ntick\r\n", buf.String())
+
+	// Test: Body shorter than the announced Content-Length closes the connection
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetKeepAlive(true)
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(10, Plain)))
+	_, err = w.Write([]byte("short"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.False(t, w.KeepAlive())
+
+	// Test: Body longer than its Content-Length is cut off where the next response would start
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetKeepAlive(true)
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(3, Plain)))
+	n, err := w.Write([]byte("hello world"))
+	require.ErrorIs(t, err, ErrContentLength)
+	assert.Equal(t, 3, n)
+	n, err = w.Write([]byte("!"))
+	require.ErrorIs(t, err, ErrContentLength)
+	assert.Equal(t, 0, n)
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\nhel"))
+	assert.True(t, w.KeepAlive())
+
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(3, Plain)))
+	n, err = w.WriteBody([]byte("hello world"))
+	require.ErrorIs(t, err, ErrContentLength)
+	assert.Equal(t, 3, n)
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\nhel"))
+}
+
+func TestLazyCommit(t *testing.T) {
+	// Test: Status line and headers wait for the first body write
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetKeepAlive(true)
+	assert.Equal(t, OK, w.StatusCode())
+	w.WriteHeader(Created)
+	w.Header().Set("X-Version", "1")
+	assert.Empty(t, buf.String())
+	assert.Equal(t, Created, w.StatusCode())
+	w.Header().Set("X-Version", "2")
+	_, err := w.Write([]byte("<html><body>hi</body></html>"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	resp := buf.String()
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 201 Created\r\nX-Version: 2\r\nDate: "))
+	assert.Contains(t, resp, "Content-Type: text/html; charset=utf-8\r\n")
+	assert.Contains(t, resp, "Content-Length: 28\r\n")
+	assert.NotContains(t, resp, "Connection")
+	assert.Equal(t, Created, w.StatusCode())
+	assert.True(t, w.KeepAlive())
+
+	// Test: Handler that writes nothing gets an empty 200
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetKeepAlive(true)
+	require.NoError(t, w.Finish())
+	resp = buf.String()
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\nDate: "))
+	assert.True(t, strings.HasSuffix(resp, "Content-Length: 0\r\n\r\n"))
+
+	// Test: Headers set by the handler win over the defaults
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Date", "Thu, 01 Jan 1970 00:00:00 GMT")
+	_, err = w.Write([]byte("{}"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Content-Type: application/json\r\n"+
+		"Date: Thu, 01 Jan 1970 00:00:00 GMT\r\n"+
+		"Content-Length: 2\r\n"+
+		"Connection: close\r\n"+
+		"\r\n"+
+		"{}", buf.String())
+
+	// Test: Only the first WriteHeader counts
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.WriteHeader(NotFound)
+	w.WriteHeader(OK)
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 404 Not Found\r\n"))
+
+	// Test: Interim status is sent right away
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.WriteHeader(Continue)
+	assert.Equal(t, "HTTP/1.1 100 Continue\r\n\r\n", buf.String())
+	w.WriteHeader(NoContent)
+	_, err = w.Write([]byte("body"))
+	require.ErrorIs(t, err, ErrBodyNotAllowed)
+}
+
+func TestReset(t *testing.T) {
+	// Test: Response held back entirely can be replaced
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Header().Set("X-Partial", "yes")
+	_, err := w.Write([]byte("partial"))
+	require.NoError(t, err)
+	require.True(t, w.Reset())
+	assert.Equal(t, StatusLineNext, w.State())
+	assert.False(t, w.Header().Has("X-Partial"))
+	require.NoError(t, w.WriteStatusLine(ServerError))
+	assert.Equal(t, "HTTP/1.1 500 Internal Server Error\r\n", buf.String())
+
+	// Test: Response that started cannot be replaced
+	buf.Reset()
+	w = NewWriter(&buf)
+	_, err = w.Write([]byte("partial"))
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	assert.False(t, w.Reset())
+}
+
+func TestHTTP10(t *testing.T) {
+	// Test: Status line carries the version and a kept alive connection is announced
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetVersion("1.0")
+	w.SetKeepAlive(true)
+	w.WriteHeader(Continue)
+	_, err := w.Write([]byte("short"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.0 200 OK\r\n"))
+	assert.Contains(t, buf.String(), "Content-Length: 5\r\nConnection: keep-alive\r\n")
+	assert.True(t, w.KeepAlive())
+
+	// Test: A long body is sent as is and ends with the connection
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetVersion("1.0")
+	w.SetKeepAlive(true)
+	body := strings.Repeat("x", bodyBufferSize+1)
+	_, err = w.Write([]byte(body))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.NotContains(t, buf.String(), "Transfer-Encoding")
+	assert.Contains(t, buf.String(), "Connection: close\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n"+body))
+	assert.False(t, w.KeepAlive())
+
+	// Test: A body the handler chunks explicitly loses its chunks and trailers
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetVersion("1.0")
+	require.NoError(t, w.WriteStatusLine(OK))
+	h := headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Trailer", "X-Sum")
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteChunkedBody([]byte("hello "))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBody([]byte("world"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Sum", "abc")
+	require.NoError(t, w.WriteTrailers(trailers))
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.0 200 OK\r\nConnection: close\r\n\r\nhello world", buf.String())
+}
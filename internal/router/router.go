@@ -0,0 +1,285 @@
+package router
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/TheBarnakhil/httpfromtcp/internal/headers"
+	"github.com/TheBarnakhil/httpfromtcp/internal/request"
+	"github.com/TheBarnakhil/httpfromtcp/internal/response"
+	"github.com/TheBarnakhil/httpfromtcp/internal/server"
+)
+
+/*
+Router dispatches requests to handlers registered for a method and a path pattern.
+
+A pattern is an optional method followed by a path, e.g. "GET /users/{id}".
+Path segments are matched literally, except for:
+  - {name} which matches any single non-empty segment
+  - {name...} as the last segment, which matches the rest of the path
+  - a trailing slash, which matches the rest of the path like an unnamed {name...}
+
+"/" therefore matches every path. Captured values are read with request.PathValue.
+Paths are matched segment by segment with each segment percent-decoded, so "%2F"
+does not split a segment.
+When several patterns match a path, the most specific one wins: literal segments
+beat {name} segments, which beat wildcards.
+
+A GET pattern also serves HEAD requests. Paths that match no pattern get a 404,
+unless adding or removing a trailing slash makes them match, in which case the
+client is redirected there. Paths that match a pattern registered for other methods
+only get a 405 with an Allow header.
+*/
+type Router struct {
+	routes []*route
+}
+
+type segmentKind int
+
+// segment kinds in ascending order of specificity
+const (
+	wildcardSegment segmentKind = iota
+	paramSegment
+	literalSegment
+)
+
+type segment struct {
+	kind segmentKind
+	// literal text, or the name of a param or wildcard
+	value string
+}
+
+type route struct {
+	method   string
+	path     string
+	segments []segment
+	handler  server.Handler
+}
+
+func New() *Router {
+	return &Router{}
+}
+
+// Handle registers handler for pattern. It panics if pattern is invalid or already registered.
+func (rt *Router) Handle(pattern string, handler server.Handler) {
+	r, err := parsePattern(pattern)
+	if err != nil {
+		panic(err)
+	}
+	for _, existing := range rt.routes {
+		if existing.method == r.method && existing.path == r.path {
+			panic(fmt.Sprintf("error: pattern %q is already registered", pattern))
+		}
+	}
+	r.handler = handler
+	rt.routes = append(rt.routes, r)
+}
+
+// Handler returns the server.Handler dispatching requests to the registered routes.
+func (rt *Router) Handler() server.Handler {
+	return rt.serve
+}
+
+func (rt *Router) serve(w *response.Writer, req *request.Request) {
+	// Segments are split on the raw path, so an escaped slash stays part of its segment
+	path, query := req.Target.RawPath, req.Target.RawQuery
+
+	matches := rt.match(path)
+
+	// Redirect if the path with or without a trailing slash matches a more specific pattern
+	alt := path + "/"
+	if strings.HasSuffix(path, "/") {
+		alt = strings.TrimSuffix(path, "/")
+	}
+	if altBest := mostSpecific(rt.match(alt)); alt != "" && altBest != nil {
+		if best := mostSpecific(matches); best == nil || altBest.moreSpecific(best) {
+			if query != "" {
+				alt += "?" + query
+			}
+			redirect(w, req, alt)
+			return
+		}
+	}
+
+	if len(matches) == 0 {
+		writeError(w, response.NotFound)
+		return
+	}
+
+	var best *route
+	var allowed []string
+	for _, r := range matches {
+		if r.allows(req.RequestLine.Method) && (best == nil || r.moreSpecific(best)) {
+			best = r
+		}
+		if r.method != "" {
+			allowed = append(allowed, r.method)
+			if r.method == request.MethodGet {
+				allowed = append(allowed, request.MethodHead)
+			}
+		}
+	}
+	if best == nil {
+		slices.Sort(allowed)
+		h := response.GetDefaultHeaders(0, response.Plain)
+		h.Set("Allow", strings.Join(slices.Compact(allowed), ", "))
+		writeErrorWithHeaders(w, response.MethodNotAllowed, h)
+		return
+	}
+
+	for name, value := range best.capture(path) {
+		req.SetPathValue(name, value)
+	}
+	best.handler(w, req)
+}
+
+// match returns the routes whose path pattern matches path, regardless of their method.
+func (rt *Router) match(path string) []*route {
+	var matches []*route
+	for _, r := range rt.routes {
+		if r.capture(path) != nil {
+			matches = append(matches, r)
+		}
+	}
+	return matches
+}
+
+func mostSpecific(routes []*route) *route {
+	var best *route
+	for _, r := range routes {
+		if best == nil || r.moreSpecific(best) {
+			best = r
+		}
+	}
+	return best
+}
+
+func parsePattern(pattern string) (*route, error) {
+	method, path, found := strings.Cut(pattern, " ")
+	if !found {
+		method, path = "", pattern
+	}
+	path = strings.TrimSpace(path)
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("error: pattern %q does not have a path starting with /", pattern)
+	}
+
+	r := &route{method: method, path: path}
+	parts := strings.Split(path[1:], "/")
+	names := map[string]bool{}
+	for i, part := range parts {
+		last := i == len(parts)-1
+		switch {
+		case part == "" && last:
+			r.segments = append(r.segments, segment{kind: wildcardSegment})
+		case strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}"):
+			name := part[1 : len(part)-1]
+			kind := paramSegment
+			if strings.HasSuffix(name, "...") {
+				if !last {
+					return nil, fmt.Errorf("error: wildcard in pattern %q is not the last segment", pattern)
+				}
+				name = strings.TrimSuffix(name, "...")
+				kind = wildcardSegment
+			}
+			if name == "" || names[name] {
+				return nil, fmt.Errorf("error: pattern %q has an empty or duplicate wildcard name", pattern)
+			}
+			names[name] = true
+			r.segments = append(r.segments, segment{kind: kind, value: name})
+		case strings.ContainsAny(part, "{}"):
+			return nil, fmt.Errorf("error: pattern %q has a malformed wildcard", pattern)
+		default:
+			r.segments = append(r.segments, segment{kind: literalSegment, value: part})
+		}
+	}
+	return r, nil
+}
+
+/*
+capture matches the escaped path against the route's pattern and returns the captured values.
+Segments are compared and captured percent-decoded.
+It returns nil if path does not match, and an empty map for a match without values.
+*/
+func (r *route) capture(path string) map[string]string {
+	if !strings.HasPrefix(path, "/") {
+		return nil
+	}
+	parts := strings.Split(path[1:], "/")
+	for i, part := range parts {
+		if decoded, err := url.PathUnescape(part); err == nil {
+			parts[i] = decoded
+		}
+	}
+	if len(parts) < len(r.segments) {
+		return nil
+	}
+	values := map[string]string{}
+	for i, seg := range r.segments {
+		switch seg.kind {
+		case literalSegment:
+			if parts[i] != seg.value {
+				return nil
+			}
+		case paramSegment:
+			if parts[i] == "" {
+				return nil
+			}
+			values[seg.value] = parts[i]
+		case wildcardSegment:
+			if seg.value != "" {
+				values[seg.value] = strings.Join(parts[i:], "/")
+			}
+			return values
+		}
+	}
+	if len(parts) != len(r.segments) {
+		return nil
+	}
+	return values
+}
+
+func (r *route) allows(method string) bool {
+	return r.method == "" || r.method == method || r.method == request.MethodGet && method == request.MethodHead
+}
+
+// moreSpecific reports whether r should win over other when both match a request.
+func (r *route) moreSpecific(other *route) bool {
+	for i := 0; i < len(r.segments) && i < len(other.segments); i++ {
+		if r.segments[i].kind != other.segments[i].kind {
+			return r.segments[i].kind > other.segments[i].kind
+		}
+	}
+	if len(r.segments) != len(other.segments) {
+		return len(r.segments) > len(other.segments)
+	}
+	// a route for the exact method beats one for any method
+	return r.method != "" && other.method == ""
+}
+
+// redirect sends the client to location, keeping the method for anything but GET and HEAD.
+func redirect(w *response.Writer, req *request.Request, location string) {
+	statusCode := response.PermanentRedirect
+	if req.RequestLine.Method == request.MethodGet || req.RequestLine.Method == request.MethodHead {
+		statusCode = response.MovedPermanently
+	}
+	h := response.GetDefaultHeaders(0, response.Plain)
+	h.Set("Location", location)
+	writeErrorWithHeaders(w, statusCode, h)
+}
+
+func writeError(w *response.Writer, statusCode response.StatusCode) {
+	writeErrorWithHeaders(w, statusCode, response.GetDefaultHeaders(0, response.Plain))
+}
+
+// writeErrorWithHeaders answers with statusCode and its reason phrase as a plain text body.
+func writeErrorWithHeaders(w *response.Writer, statusCode response.StatusCode, h *headers.Headers) {
+	body := []byte(fmt.Sprintf("%d %s\n", statusCode, response.StatusText(statusCode)))
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(h)
+	w.WriteBody(body)
+}
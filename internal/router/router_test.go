@@ -0,0 +1,140 @@
+package router
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/TheBarnakhil/httpfromtcp/internal/request"
+	"github.com/TheBarnakhil/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouter(t *testing.T) {
+	rt := New()
+	rt.Handle("GET /users/{id}", textHandler(func(req *request.Request) string {
+		return "user " + req.PathValue("id")
+	}))
+	rt.Handle("DELETE /users/{id}", textHandler(func(req *request.Request) string {
+		return "deleted " + req.PathValue("id")
+	}))
+	rt.Handle("GET /users/me", textHandler(func(req *request.Request) string {
+		return "me"
+	}))
+	rt.Handle("/files/{path...}", textHandler(func(req *request.Request) string {
+		return "file " + req.PathValue("path")
+	}))
+	rt.Handle("/static/", textHandler(func(req *request.Request) string {
+		return "static"
+	}))
+	rt.Handle("POST /upload", textHandler(func(req *request.Request) string {
+		return "upload"
+	}))
+
+	// Test: Path parameter
+	resp := serve(t, rt, "GET /users/42 HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(resp, "user 42"))
+
+	// Test: Query string is not part of the path
+	resp = serve(t, rt, "GET /users/42?verbose=1 HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasSuffix(resp, "user 42"))
+
+	// Test: Literal segment beats parameter
+	resp = serve(t, rt, "GET /users/me HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasSuffix(resp, "me"))
+
+	// Test: Method matching
+	resp = serve(t, rt, "DELETE /users/42 HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasSuffix(resp, "deleted 42"))
+
+	// Test: GET pattern serves HEAD
+	resp = serve(t, rt, "HEAD /users/42 HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+
+	// Test: Wildcard captures the rest of the path
+	resp = serve(t, rt, "GET /files/a/b/c.txt HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasSuffix(resp, "file a/b/c.txt"))
+
+	// Test: Segments are matched and captured decoded, an escaped slash does not split them
+	resp = serve(t, rt, "GET /users/j%C3%BCrgen HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasSuffix(resp, "user jürgen"))
+	resp = serve(t, rt, "GET /users/a%2Fb HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasSuffix(resp, "user a/b"))
+	resp = serve(t, rt, "GET /user%73/me HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasSuffix(resp, "me"))
+
+	// Test: Trailing slash matches a subtree
+	resp = serve(t, rt, "GET /static/css/site.css HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasSuffix(resp, "static"))
+
+	// Test: Subtree root without the trailing slash is redirected
+	resp = serve(t, rt, "GET /static?v=1 HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 301 Moved Permanently\r\n"))
+	assert.Contains(t, resp, "Location: /static/?v=1\r\n")
+
+	// Test: Extra trailing slash is redirected, keeping the method
+	resp = serve(t, rt, "POST /upload/ HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 308 Permanent Redirect\r\n"))
+	assert.Contains(t, resp, "Location: /upload\r\n")
+
+	// Test: Unknown path
+	resp = serve(t, rt, "GET /nowhere HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 404 Not Found\r\n"))
+
+	// Test: Empty parameter does not match
+	resp = serve(t, rt, "GET /users/ HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 404 Not Found\r\n"))
+
+	// Test: Known path with another method
+	resp = serve(t, rt, "PUT /users/42 HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 405 Method Not Allowed\r\n"))
+	assert.Contains(t, resp, "Allow: DELETE, GET, HEAD\r\n")
+}
+
+func TestCatchAll(t *testing.T) {
+	rt := New()
+	rt.Handle("/", textHandler(func(req *request.Request) string {
+		return "root"
+	}))
+	rt.Handle("/docs/", textHandler(func(req *request.Request) string {
+		return "docs"
+	}))
+
+	// Test: "/" matches every path
+	resp := serve(t, rt, "PATCH /anything/at/all HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasSuffix(resp, "root"))
+
+	// Test: A more specific subtree is still redirected to
+	resp = serve(t, rt, "GET /docs HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 301 Moved Permanently\r\n"))
+}
+
+func TestInvalidPatterns(t *testing.T) {
+	rt := New()
+	rt.Handle("GET /users/{id}", textHandler(nil))
+
+	assert.Panics(t, func() { rt.Handle("GET /users/{id}", textHandler(nil)) })
+	assert.Panics(t, func() { rt.Handle("users", textHandler(nil)) })
+	assert.Panics(t, func() { rt.Handle("/{path...}/edit", textHandler(nil)) })
+	assert.Panics(t, func() { rt.Handle("/{a}/{a}", textHandler(nil)) })
+	assert.Panics(t, func() { rt.Handle("/a{b}", textHandler(nil)) })
+}
+
+func textHandler(text func(req *request.Request) string) func(w *response.Writer, req *request.Request) {
+	return func(w *response.Writer, req *request.Request) {
+		body := text(req)
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body), response.Plain))
+		w.WriteBody([]byte(body))
+	}
+}
+
+func serve(t *testing.T, rt *Router, raw string) string {
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	rt.Handler()(response.NewWriter(&buf), req)
+	return buf.String()
+}
@@ -0,0 +1,360 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/TheBarnakhil/httpfromtcp/internal/headers"
+	"github.com/TheBarnakhil/httpfromtcp/internal/request"
+	"github.com/TheBarnakhil/httpfromtcp/internal/response"
+)
+
+/*
+FromHTTP adapts a net/http handler, e.g. one from net/http/pprof, so this server can serve it.
+The request body is streamed to the handler and what it writes is streamed to the client,
+including flushes and trailers, declared in the Trailer header or prefixed with http.TrailerPrefix.
+*/
+func FromHTTP(h http.Handler) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		r, err := toHTTPRequest(req)
+		if err != nil {
+			errorPage(response.BadRequest, err.Error()).writeHandlerErrortoWriter(w)
+			return
+		}
+		rw := &httpResponseWriter{w: w, header: http.Header{}}
+		for key, val := range w.Header().All() {
+			rw.header.Add(key, val)
+		}
+		h.ServeHTTP(rw, r)
+		rw.finish()
+	}
+}
+
+/*
+ToHTTP adapts a Handler to net/http, e.g. to test it with net/http/httptest or to mount it
+on an http.ServeMux. The response the handler writes is parsed as it is written and streamed
+to the http.ResponseWriter, which is flushed whenever a part of the body got through.
+*/
+func ToHTTP(h Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := fromHTTPRequest(r)
+		pr, pw := io.Pipe()
+		copied := make(chan struct{})
+		go func() {
+			defer close(copied)
+			br := bufio.NewReader(pr)
+			copyResponse(w, r, br)
+			// Whatever is left, like a body written for a HEAD request, must not block the handler
+			io.Copy(io.Discard, br)
+		}()
+		defer func() {
+			pw.Close()
+			<-copied
+		}()
+
+		writer := response.NewWriter(pw)
+		// net/http decides itself whether the connection stays open
+		writer.SetKeepAlive(true)
+		writer.SetHead(r.Method == "HEAD")
+		h(writer, req)
+		writer.Finish()
+	})
+}
+
+// toHTTPRequest translates req into the request a net/http handler expects on the server side.
+func toHTTPRequest(req *request.Request) (*http.Request, error) {
+	target := req.RequestLine.RequestTarget
+	u := &url.URL{Path: "*"}
+	if target != "*" {
+		var err error
+		u, err = url.ParseRequestURI(target)
+		if err != nil {
+			return nil, fmt.Errorf("error: Invalid request target %q", target)
+		}
+	}
+	proto := "HTTP/" + req.RequestLine.HttpVersion
+	major, minor, ok := http.ParseHTTPVersion(proto)
+	if !ok {
+		return nil, fmt.Errorf("error: Invalid HTTP version %q", req.RequestLine.HttpVersion)
+	}
+
+	r := &http.Request{
+		Method:     req.RequestLine.Method,
+		URL:        u,
+		Proto:      proto,
+		ProtoMajor: major,
+		ProtoMinor: minor,
+		Header:     http.Header{},
+		Host:       u.Host,
+		RequestURI: target,
+		RemoteAddr: req.RemoteAddr,
+		TLS:        req.TLS,
+	}
+	for key, val := range req.Headers.All() {
+		if strings.EqualFold(key, "Host") {
+			r.Host = val
+			continue
+		}
+		r.Header.Add(key, val)
+	}
+
+	te, _ := req.Headers.Get("Transfer-Encoding")
+	if strings.Contains(strings.ToLower(te), "chunked") {
+		r.Header.Del("Transfer-Encoding")
+		r.TransferEncoding = []string{"chunked"}
+		r.ContentLength = -1
+		for _, name := range r.Header.Values("Trailer") {
+			for _, key := range strings.Split(name, ",") {
+				if r.Trailer == nil {
+					r.Trailer = http.Header{}
+				}
+				r.Trailer[http.CanonicalHeaderKey(strings.TrimSpace(key))] = nil
+			}
+		}
+	} else if cl, ok := req.Headers.Get("Content-Length"); ok {
+		r.ContentLength, _ = strconv.ParseInt(cl, 10, 64)
+	}
+
+	copyTrailers := func() {
+		for key, val := range req.Trailers.All() {
+			if r.Trailer == nil {
+				r.Trailer = http.Header{}
+			}
+			r.Trailer.Add(key, val)
+		}
+	}
+	switch {
+	case req.Body != nil:
+		// RequestFromReader read the body already
+		r.Body = io.NopCloser(bytes.NewReader(req.Body))
+		copyTrailers()
+	case req.BodyReader != nil:
+		r.Body = &trailerReader{ReadCloser: req.BodyReader, done: copyTrailers}
+	default:
+		r.Body = http.NoBody
+	}
+	return r, nil
+}
+
+// fromHTTPRequest translates a request received by net/http into a request.Request.
+func fromHTTPRequest(r *http.Request) *request.Request {
+	target := r.RequestURI
+	if target == "" {
+		target = r.URL.RequestURI()
+	}
+	// net/http validated the target already
+	parsed, _ := request.ParseTarget(r.Method, target)
+	req := &request.Request{
+		Target: parsed,
+		RequestLine: request.RequestLine{
+			HttpVersion:   fmt.Sprintf("%d.%d", r.ProtoMajor, r.ProtoMinor),
+			RequestTarget: target,
+			Method:        r.Method,
+		},
+		ParserState: request.Done,
+		Headers:     headers.NewHeaders(),
+		Trailers:    headers.NewHeaders(),
+		RemoteAddr:  r.RemoteAddr,
+		TLS:         r.TLS,
+	}
+	// net/http moves these out of the header map
+	req.Headers.Set("Host", r.Host)
+	for _, key := range slices.Sorted(maps.Keys(r.Header)) {
+		for _, val := range r.Header[key] {
+			req.Headers.Add(key, val)
+		}
+	}
+	if r.ContentLength > 0 {
+		req.Headers.Set("Content-Length", strconv.FormatInt(r.ContentLength, 10))
+	} else if r.ContentLength < 0 {
+		req.Headers.Set("Transfer-Encoding", "chunked")
+	}
+
+	body := r.Body
+	if body == nil {
+		body = http.NoBody
+	}
+	req.BodyReader = &trailerReader{ReadCloser: body, done: func() {
+		for _, key := range slices.Sorted(maps.Keys(r.Trailer)) {
+			for _, val := range r.Trailer[key] {
+				req.Trailers.Add(key, val)
+			}
+		}
+	}}
+	return req
+}
+
+/*
+copyResponse reads the response a Handler writes from br and replays it on w.
+Interim 1xx responses are passed on as they come, the final one is streamed.
+*/
+func copyResponse(w http.ResponseWriter, r *http.Request, br *bufio.Reader) {
+	for {
+		resp, err := http.ReadResponse(br, r)
+		if err != nil {
+			http.Error(w, "invalid response from handler", http.StatusInternalServerError)
+			return
+		}
+		for key, vals := range resp.Header {
+			// hop-by-hop, net/http manages the connection itself
+			if key != "Connection" {
+				w.Header()[key] = vals
+			}
+		}
+		if resp.StatusCode < 200 && resp.StatusCode != http.StatusSwitchingProtocols {
+			w.WriteHeader(resp.StatusCode)
+			for key := range resp.Header {
+				w.Header().Del(key)
+			}
+			continue
+		}
+
+		for key := range resp.Trailer {
+			w.Header().Add("Trailer", key)
+		}
+		w.WriteHeader(resp.StatusCode)
+		flusher, _ := w.(http.Flusher)
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := resp.Body.Read(buf)
+			if n > 0 {
+				if _, werr := w.Write(buf[:n]); werr != nil {
+					break
+				}
+				if flusher != nil {
+					flusher.Flush()
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+		resp.Body.Close()
+		for key, vals := range resp.Trailer {
+			w.Header()[key] = vals
+		}
+		return
+	}
+}
+
+// trailerReader calls done once the body it wraps was read to the end, which is when its trailers are known.
+type trailerReader struct {
+	io.ReadCloser
+	done func()
+}
+
+func (t *trailerReader) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	if errors.Is(err, io.EOF) && t.done != nil {
+		t.done()
+		t.done = nil
+	}
+	return n, err
+}
+
+// httpResponseWriter is the http.ResponseWriter FromHTTP hands to net/http handlers.
+type httpResponseWriter struct {
+	w           *response.Writer
+	header      http.Header
+	wroteHeader bool
+}
+
+func (rw *httpResponseWriter) Header() http.Header {
+	return rw.header
+}
+
+/*
+WriteHeader passes the headers as they are now on to the response writer, along with statusCode.
+Like with net/http, changing them afterwards has no effect, except for trailers.
+*/
+func (rw *httpResponseWriter) WriteHeader(statusCode int) {
+	if rw.wroteHeader {
+		return
+	}
+	rw.copyHeader()
+	if statusCode >= 100 && statusCode < 200 && statusCode != http.StatusSwitchingProtocols {
+		// an interim response, the final one follows
+		rw.w.WriteHeader(response.StatusCode(statusCode))
+		return
+	}
+	rw.wroteHeader = true
+	if rw.header.Get("Trailer") != "" && rw.header.Get("Content-Length") == "" {
+		// trailers can only follow a chunked body
+		rw.w.Header().Set("Transfer-Encoding", "chunked")
+	}
+	rw.w.WriteHeader(response.StatusCode(statusCode))
+}
+
+func (rw *httpResponseWriter) Write(p []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	return rw.w.Write(p)
+}
+
+// Flush implements http.Flusher.
+func (rw *httpResponseWriter) Flush() {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	rw.w.Flush()
+}
+
+// copyHeader replaces the headers of the response writer with those the handler set so far.
+func (rw *httpResponseWriter) copyHeader() {
+	h := rw.w.Header()
+	var names []string
+	for key := range h.All() {
+		names = append(names, key)
+	}
+	for _, key := range names {
+		h.Del(key)
+	}
+	for _, key := range slices.Sorted(maps.Keys(rw.header)) {
+		if strings.HasPrefix(key, http.TrailerPrefix) {
+			continue
+		}
+		for _, val := range rw.header[key] {
+			h.Add(key, val)
+		}
+	}
+}
+
+// finish completes the response once the handler returned, sending trailers if it set any.
+func (rw *httpResponseWriter) finish() {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	trailers := headers.NewHeaders()
+	for _, name := range rw.header.Values("Trailer") {
+		for _, key := range strings.Split(name, ",") {
+			for _, val := range rw.header.Values(strings.TrimSpace(key)) {
+				trailers.Add(strings.TrimSpace(key), val)
+			}
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(rw.header)) {
+		if name, ok := strings.CutPrefix(key, http.TrailerPrefix); ok {
+			for _, val := range rw.header[key] {
+				trailers.Add(name, val)
+			}
+		}
+	}
+	if trailers.Len() == 0 {
+		return
+	}
+	// This fails if the body was not chunked, then the trailers cannot be sent
+	if _, err := rw.w.WriteChunkedBodyDone(); err != nil {
+		return
+	}
+	rw.w.WriteTrailers(trailers)
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/TheBarnakhil/httpfromtcp/internal/headers"
+	"github.com/TheBarnakhil/httpfromtcp/internal/request"
+	"github.com/TheBarnakhil/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromHTTP(t *testing.T) {
+	// Test: Request with a chunked body and trailers, response with a flush and trailers
+	raw := "POST /echo?x=1 HTTP/1.1\r\n" +
+		"Host: example.com\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"Trailer: X-Checksum\r\n" +
+		"\r\n" +
+		"5\r\nhello\r\n" +
+		"0\r\nX-Checksum: abc\r\n\r\n"
+	req, err := request.StreamingRequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+
+	handler := FromHTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "/echo", r.URL.Path)
+		assert.Equal(t, "1", r.URL.Query().Get("x"))
+		assert.Equal(t, "example.com", r.Host)
+		assert.Equal(t, int64(-1), r.ContentLength)
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(body))
+		assert.Equal(t, "abc", r.Trailer.Get("X-Checksum"))
+
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write(body)
+		w.(http.Flusher).Flush()
+		w.Write([]byte(" world"))
+		w.Header().Set(http.TrailerPrefix+"X-Length", "11")
+	}))
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	w.SetKeepAlive(true)
+	handler(w, req)
+	require.NoError(t, w.Finish())
+	resp := buf.String()
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 202 Accepted\r\nContent-Type: text/plain\r\n"))
+	assert.Contains(t, resp, "Transfer-Encoding: chunked\r\n")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\n5\r\nhello\r\n6\r\n world\r\n0\r\nX-Length: 11\r\n\r\n"))
+	assert.True(t, w.KeepAlive())
+
+	// Test: Headers set by middleware are visible and can be overridden
+	req, err = request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	require.NoError(t, err)
+	buf.Reset()
+	w = response.NewWriter(&buf)
+	w.Header().Set("X-Request-Id", "abc")
+	w.Header().Set("X-Frame-Options", "DENY")
+	FromHTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "abc", w.Header().Get("X-Request-Id"))
+		w.Header().Del("X-Frame-Options")
+		io.WriteString(w, "ok")
+	}))(w, req)
+	require.NoError(t, w.Finish())
+	resp = buf.String()
+	assert.Contains(t, resp, "X-Request-Id: abc\r\n")
+	assert.NotContains(t, resp, "X-Frame-Options")
+	assert.Contains(t, resp, "Content-Length: 2\r\n")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\nok"))
+}
+
+func TestToHTTP(t *testing.T) {
+	handler := ToHTTP(func(w *response.Writer, req *request.Request) {
+		body, err := req.ReadBody()
+		require.NoError(t, err)
+		checksum, _ := req.Trailers.Get("X-Checksum")
+		host, _ := req.Headers.Get("Host")
+
+		w.Header().Set("Trailer", "X-Length")
+		w.Header().Set("Transfer-Encoding", "chunked")
+		w.Header().Set("X-Host", host)
+		w.WriteHeader(response.Created)
+		w.WriteChunkedBody(body)
+		w.Flush()
+		w.WriteChunkedBody([]byte(" " + checksum))
+		w.WriteChunkedBodyDone()
+		trailers := headers.NewHeaders()
+		trailers.Set("X-Length", "9")
+		w.WriteTrailers(trailers)
+	})
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	// Test: Streamed request body with trailers in, chunked response with trailers out
+	req, err := http.NewRequest("POST", srv.URL+"/upload", io.MultiReader(strings.NewReader("hello")))
+	require.NoError(t, err)
+	req.Trailer = http.Header{"X-Checksum": {"abc"}}
+	resp, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, strings.TrimPrefix(srv.URL, "http://"), resp.Header.Get("X-Host"))
+	assert.Empty(t, resp.Header.Get("Connection"))
+	assert.Equal(t, "hello abc", string(body))
+	assert.Equal(t, "9", resp.Trailer.Get("X-Length"))
+
+	// Test: Lazily written response through a recorder
+	rec := httptest.NewRecorder()
+	ToHTTP(func(w *response.Writer, req *request.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(response.NotFound)
+		w.Write([]byte(`{"error":"` + req.RequestLine.RequestTarget + `"}`))
+	}).ServeHTTP(rec, httptest.NewRequest("GET", "/missing?q=1", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, `{"error":"/missing?q=1"}`, rec.Body.String())
+}
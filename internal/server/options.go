@@ -0,0 +1,81 @@
+package server
+
+import (
+	"time"
+
+	"github.com/TheBarnakhil/httpfromtcp/internal/request"
+)
+
+// Option configures a Server before it starts accepting connections.
+type Option func(*Server)
+
+// WithLimits bounds the size of the requests the server accepts.
+func WithLimits(limits request.Limits) Option {
+	return func(s *Server) {
+		s.Limits = limits
+	}
+}
+
+// WithReadHeaderTimeout bounds how long a client may take to send the request line and headers.
+func WithReadHeaderTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.ReadHeaderTimeout = timeout
+	}
+}
+
+// WithReadTimeout bounds how long a client may take to send a whole request.
+func WithReadTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.ReadTimeout = timeout
+	}
+}
+
+// WithWriteTimeout bounds how long writing a response may take.
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.WriteTimeout = timeout
+	}
+}
+
+// WithIdleTimeout bounds how long a kept alive connection may wait for the next request.
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.IdleTimeout = timeout
+	}
+}
+
+// WithCertificate adds a certificate a server started with ServeTLS presents to clients asking for one of its names.
+func WithCertificate(certFile, keyFile string) Option {
+	return func(s *Server) {
+		s.keyPairs = append(s.keyPairs, keyPair{certFile: certFile, keyFile: keyFile})
+	}
+}
+
+// WithMinTLSVersion sets the oldest TLS version a server started with ServeTLS accepts, e.g. tls.VersionTLS13.
+func WithMinTLSVersion(version uint16) Option {
+	return func(s *Server) {
+		if s.TLSConfig != nil {
+			s.TLSConfig.MinVersion = version
+		}
+	}
+}
+
+// WithCipherSuites restricts the cipher suites a server started with ServeTLS negotiates below TLS 1.3.
+func WithCipherSuites(suites ...uint16) Option {
+	return func(s *Server) {
+		if s.TLSConfig != nil {
+			s.TLSConfig.CipherSuites = suites
+		}
+	}
+}
+
+/*
+WithMethods sets the methods the server implements, requests with any other method get a 501.
+Extension methods are added to the standard ones with
+WithMethods(append(request.StandardMethods(), "PURGE")...).
+*/
+func WithMethods(methods ...string) Option {
+	return func(s *Server) {
+		s.Methods = methods
+	}
+}
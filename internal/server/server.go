@@ -1,11 +1,19 @@
 package server
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"html"
+	"io"
 	"log"
 	"net"
+	"os"
+	"runtime/debug"
+	"slices"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/TheBarnakhil/httpfromtcp/internal/request"
 	"github.com/TheBarnakhil/httpfromtcp/internal/response"
@@ -15,6 +23,32 @@ type Server struct {
 	Listener    net.Listener
 	Open        atomic.Bool
 	HandlerFunc Handler
+	Limits      request.Limits
+
+	// Methods are the methods the server implements, others get a 501, nil stands for request.StandardMethods
+	Methods []string
+
+	// ReadHeaderTimeout bounds reading the request line and headers, ReadTimeout is used if it is zero
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout bounds reading a whole request, body included
+	ReadTimeout time.Duration
+	// WriteTimeout bounds writing the response, starting once the request headers are read
+	WriteTimeout time.Duration
+	// IdleTimeout bounds waiting for the next request on a kept alive connection, ReadTimeout is used if it is zero
+	IdleTimeout time.Duration
+
+	// TLSConfig is the configuration of a server started with ServeTLS, nil for plaintext
+	TLSConfig *tls.Config
+
+	mu sync.Mutex
+	// open connections, mapped to whether they are idle between requests
+	conns map[net.Conn]bool
+
+	// files the certificates of a TLS server are loaded from, and the certificates loaded last
+	keyPairs      []keyPair
+	certificates  atomic.Pointer[[]tls.Certificate]
+	stopReload    chan struct{}
+	reloadStopped atomic.Bool
 }
 
 type HandlerError struct {
@@ -22,61 +56,216 @@ type HandlerError struct {
 	Message    string
 }
 
+// maxDiscardBody is how much of an unread request body is skipped to keep a connection alive
+const maxDiscardBody = 256 << 10
+
 type Handler func(w *response.Writer, req *request.Request)
 
-func Serve(port int, handlerFunc Handler) (*Server, error) {
+// Middleware wraps a Handler to add behavior around it.
+type Middleware func(next Handler) Handler
+
+/*
+Chain wraps handler in middlewares. The first middleware is the outermost one,
+so it sees the request first and the finished response last.
+*/
+func Chain(handler Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+func Serve(port int, handlerFunc Handler, opts ...Option) (*Server, error) {
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return &Server{}, errors.New("error: Error creating a listener")
 	}
 	server := Server{Listener: listener, HandlerFunc: handlerFunc}
+	for _, opt := range opts {
+		opt(&server)
+	}
 	server.Open.Store(true)
 	go server.listen()
 	return &server, nil
 }
 
+/*
+Close stops the server immediately, closing the listener and every open connection
+including those with a request in flight. Use Shutdown to let them finish.
+*/
 func (s *Server) Close() error {
 	s.Open.Store(false)
+	s.stopReloading()
+	var err error
 	if s.Listener != nil {
-		return s.Listener.Close()
+		err = s.Listener.Close()
 	}
-	return nil
+	s.closeConns(false)
+	return err
 }
 
 func (s *Server) listen() {
 	for s.Open.Load() {
 		conn, err := s.Listener.Accept()
 		if err != nil {
+			if !s.Open.Load() {
+				return
+			}
 			log.Printf("Error accepting connection %v", err)
+			continue
 		}
+		s.setIdle(conn, true)
 		go s.handle(conn)
 	}
 }
 
+/*
+handle serves requests from conn until the client or the handler asks for the
+connection to be closed, or until a response cannot be delimited without closing it.
+A panic while serving only takes down this connection.
+*/
 func (s *Server) handle(conn net.Conn) {
+	defer s.forget(conn)
 	defer conn.Close()
 
-	writer := response.NewWriter(conn)
+	var writer *response.Writer
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("Panic serving %v: %v\n%s", conn.RemoteAddr(), err, debug.Stack())
+			// Only answer if the client has not received a part of another response yet
+			if writer != nil && writer.Reset() {
+				writer.SetKeepAlive(false)
+				serverError().writeHandlerErrortoWriter(writer)
+			}
+		}
+	}()
 
-	req, err := request.RequestFromReader(conn)
-	if err != nil {
-		hErr := &HandlerError{
-			StatusCode: response.BadRequest,
-			Message: fmt.Sprintf(`
+	reader := s.Limits.NewReader(conn)
+	for first := true; ; first = false {
+		start := time.Now()
+
+		// Wait for the first byte of the next request, a client that sends nothing is dropped without a response
+		wait := s.IdleTimeout
+		if first || wait == 0 {
+			wait = s.headerTimeout()
+		}
+		if !s.setIdle(conn, true) {
+			return
+		}
+		conn.SetReadDeadline(deadline(start, wait))
+		if _, err := reader.Peek(1); err != nil {
+			return
+		}
+		s.setIdle(conn, false)
+		if !first {
+			start = time.Now()
+		}
+
+		conn.SetReadDeadline(deadline(start, s.headerTimeout()))
+		writer = response.NewWriter(conn)
+
+		req, err := request.StreamingRequestFromReaderWithLimits(reader, s.Limits)
+		conn.SetWriteDeadline(deadline(time.Now(), s.WriteTimeout))
+		if req != nil {
+			writer.SetVersion(req.RequestLine.HttpVersion)
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				// client closed the connection between requests
+				return
+			}
+			parseError(err).writeHandlerErrortoWriter(writer)
+			return
+		}
+		conn.SetReadDeadline(deadline(start, s.ReadTimeout))
+		req.RemoteAddr = conn.RemoteAddr().String()
+		if tlsConn, ok := conn.(*tls.Conn); ok {
+			state := tlsConn.ConnectionState()
+			req.TLS = &state
+		}
+
+		writer.SetKeepAlive(req.KeepAlive() && s.Open.Load())
+		writer.SetHead(req.RequestLine.Method == request.MethodHead)
+		handler := s.HandlerFunc
+		if !s.implements(req.RequestLine.Method) {
+			handler = notImplemented
+		}
+		handler(writer, req)
+		if err := writer.Finish(); err != nil {
+			return
+		}
+		if !writer.KeepAlive() {
+			return
+		}
+		// The next request starts after whatever the handler left of this body
+		if err := req.DiscardBody(maxDiscardBody); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) headerTimeout() time.Duration {
+	if s.ReadHeaderTimeout != 0 {
+		return s.ReadHeaderTimeout
+	}
+	return s.ReadTimeout
+}
+
+// deadline returns the point in time timeout after start, or no deadline at all for a zero timeout.
+func deadline(start time.Time, timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return start.Add(timeout)
+}
+
+// parseError builds the response for a request that could not be parsed.
+func parseError(err error) *HandlerError {
+	statusCode := response.BadRequest
+	var parseErr *request.ParseError
+	switch {
+	case errors.As(err, &parseErr):
+		statusCode = response.StatusCode(parseErr.StatusCode)
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		statusCode = response.RequestTimeout
+	}
+	return errorPage(statusCode, "Your request honestly kinda sucked. "+err.Error())
+}
+
+// implements reports whether method is one the server serves.
+func (s *Server) implements(method string) bool {
+	if s.Methods == nil {
+		return slices.Contains(request.StandardMethods(), method)
+	}
+	return slices.Contains(s.Methods, method)
+}
+
+// notImplemented answers requests with a method the server does not implement.
+func notImplemented(w *response.Writer, req *request.Request) {
+	errorPage(response.NotImplemented, fmt.Sprintf("The %s method is not supported.", req.RequestLine.Method)).writeHandlerErrortoWriter(w)
+}
+
+// serverError builds the response for a request whose handler panicked.
+func serverError() *HandlerError {
+	return errorPage(response.ServerError, "Okay, you know what? This one is on me.")
+}
+
+func errorPage(statusCode response.StatusCode, detail string) *HandlerError {
+	reason := response.StatusText(statusCode)
+	return &HandlerError{
+		StatusCode: statusCode,
+		Message: fmt.Sprintf(`
 		<html>
   <head>
-    <title>500 Internal Server Error</title>
+    <title>%d %s</title>
   </head>
   <body>
-    <h1>Internal Server Error</h1>
-    <p>Okay, you know what? This one is on me. %v</p>
+    <h1>%s</h1>
+    <p>%s</p>
   </body>
 </html>
-		`, err),
-		}
-		hErr.writeHandlerErrortoWriter(writer)
+		`, statusCode, reason, reason, html.EscapeString(detail)),
 	}
-	s.HandlerFunc(writer, req)
 }
 
 func (h HandlerError) writeHandlerErrortoWriter(w *response.Writer) {
@@ -0,0 +1,365 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TheBarnakhil/httpfromtcp/internal/headers"
+	"github.com/TheBarnakhil/httpfromtcp/internal/request"
+	"github.com/TheBarnakhil/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMethodAllowlist(t *testing.T) {
+	srv, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		w.Write([]byte(req.RequestLine.Method))
+	}, WithMethods(append(request.StandardMethods(), "PURGE")...))
+	require.NoError(t, err)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+
+	// Test: Extension method that was allowed
+	_, err = io.WriteString(conn, "PURGE / HTTP/1.1\r\nHost: localhost\r\n\r\nBREW / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	resp, err := response.ResponseFromReader(br, "PURGE")
+	require.NoError(t, err)
+	assert.Equal(t, "PURGE", string(resp.Body))
+
+	// Test: Extension method that was not
+	resp, err = response.ResponseFromReader(br, "BREW")
+	require.NoError(t, err)
+	assert.Equal(t, response.NotImplemented, resp.StatusLine.StatusCode)
+}
+
+func TestParseErrorStatus(t *testing.T) {
+	srv, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		w.Write([]byte("hello"))
+	}, WithLimits(request.Limits{MaxRequestLineBytes: 30, MaxHeaderCount: 2, MaxBodyBytes: 5}))
+	require.NoError(t, err)
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		raw        string
+		statusCode response.StatusCode
+	}{
+		{"GET /a-very-long-coffee-order HTTP/1.1\r\n\r\n", response.URITooLong},
+		{"GET / HTTP/1.1\r\nA: 1\r\nB: 2\r\nC: 3\r\n\r\n", response.RequestHeaderFieldsTooLarge},
+		{"POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 6\r\n\r\nhello!", response.ContentTooLarge},
+		{"GET / HTTP/1.1\r\nHo st: localhost\r\n\r\n", response.BadRequest},
+		{"GET /\r\n\r\n", response.BadRequest},
+		{"GET / HTTP/3.0\r\n\r\n", response.HTTPVersionNotSupported},
+	} {
+		conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = io.WriteString(conn, tc.raw)
+		require.NoError(t, err)
+		resp, err := response.ResponseFromReader(conn, "GET")
+		require.NoError(t, err, tc.raw)
+		assert.Equal(t, tc.statusCode, resp.StatusLine.StatusCode, tc.raw)
+	}
+}
+
+func TestServe(t *testing.T) {
+	srv, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		switch req.RequestLine.RequestTarget {
+		case "/hash":
+			body := []byte("some content to hash")
+			sum := sha256.Sum256(body)
+			w.WriteStatusLine(response.OK)
+			h := headers.NewHeaders()
+			h.Set("Transfer-Encoding", "chunked")
+			h.Set("Trailer", "X-Content-SHA256")
+			w.WriteHeaders(h)
+			w.WriteChunkedBody(body)
+			w.WriteChunkedBodyDone()
+			trailers := headers.NewHeaders()
+			trailers.Set("X-Content-SHA256", fmt.Sprintf("%x", sum))
+			w.WriteTrailers(trailers)
+		case "/panic":
+			panic("boom")
+		case "/head":
+			// the length of the body a GET would get, which a HEAD handler does not write
+			w.Header().Set("Content-Length", "10")
+		default:
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("hello"))
+		}
+	})
+	require.NoError(t, err)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+
+	// Test: Several requests on one connection
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\nHEAD / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	resp, err := response.ResponseFromReader(br, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, response.OK, resp.StatusLine.StatusCode)
+	assert.Equal(t, "hello", string(resp.Body))
+	assert.True(t, resp.KeepAlive())
+	resp, err = response.ResponseFromReader(br, "HEAD")
+	require.NoError(t, err)
+	length, _ := resp.Headers.Get("Content-Length")
+	assert.Equal(t, "5", length)
+
+	// Test: HEAD handler announcing a length without writing a body keeps the connection
+	_, err = io.WriteString(conn, "HEAD /head HTTP/1.1\r\nHost: localhost\r\n\r\nGET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	resp, err = response.ResponseFromReader(br, "HEAD")
+	require.NoError(t, err)
+	length, _ = resp.Headers.Get("Content-Length")
+	assert.Equal(t, "10", length)
+	assert.True(t, resp.KeepAlive())
+	resp, err = response.ResponseFromReader(br, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(resp.Body))
+
+	// Test: Chunked body with a trailer that matches it
+	_, err = io.WriteString(conn, "GET /hash HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	resp, err = response.ResponseFromReader(br, "GET")
+	require.NoError(t, err)
+	sum, _ := resp.Trailers.Get("X-Content-SHA256")
+	assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256(resp.Body)), sum)
+
+	// Test: Panicking handler gets a 500 and the connection is closed
+	_, err = io.WriteString(conn, "GET /panic HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	resp, err = response.ResponseFromReader(br, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, response.ServerError, resp.StatusLine.StatusCode)
+	assert.False(t, resp.KeepAlive())
+	_, err = response.ResponseFromReader(br, "GET")
+	require.ErrorIs(t, err, io.EOF)
+
+	// Test: Malformed request
+	conn, err = net.Dial("tcp", srv.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "garbage\r\n\r\n")
+	require.NoError(t, err)
+	resp, err = response.ResponseFromReader(conn, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, response.BadRequest, resp.StatusLine.StatusCode)
+
+	// Test: Unknown method gets a 501 and the connection stays usable
+	conn, err = net.Dial("tcp", srv.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	br = bufio.NewReader(conn)
+	_, err = io.WriteString(conn, "PURGE / HTTP/1.1\r\nHost: localhost\r\n\r\nGET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	resp, err = response.ResponseFromReader(br, "PURGE")
+	require.NoError(t, err)
+	assert.Equal(t, response.NotImplemented, resp.StatusLine.StatusCode)
+	resp, err = response.ResponseFromReader(br, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(resp.Body))
+
+	// Test: HTTP/1.0 is answered in kind and the connection closed
+	conn, err = net.Dial("tcp", srv.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET / HTTP/1.0\r\n\r\n")
+	require.NoError(t, err)
+	raw, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(raw), "HTTP/1.0 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(string(raw), "hello"))
+
+	// Test: Unsupported version
+	conn, err = net.Dial("tcp", srv.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET / HTTP/2.0\r\n\r\n")
+	require.NoError(t, err)
+	resp, err = response.ResponseFromReader(conn, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, response.HTTPVersionNotSupported, resp.StatusLine.StatusCode)
+
+	// Test: Unknown transfer coding gets a 501, conflicting framing a 400
+	for raw, statusCode := range map[string]response.StatusCode{
+		"Transfer-Encoding: gzip, chunked\r\n":                response.NotImplemented,
+		"Transfer-Encoding: chunked\r\nContent-Length: 5\r\n": response.BadRequest,
+	} {
+		conn, err = net.Dial("tcp", srv.Listener.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = io.WriteString(conn, "POST / HTTP/1.1\r\nHost: localhost\r\n"+raw+"\r\n0\r\n\r\n")
+		require.NoError(t, err)
+		resp, err = response.ResponseFromReader(conn, "POST")
+		require.NoError(t, err)
+		assert.Equal(t, statusCode, resp.StatusLine.StatusCode)
+		assert.False(t, resp.KeepAlive())
+	}
+
+	// Test: Malformed percent-encoding in the request target
+	conn, err = net.Dial("tcp", srv.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET /%zz HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	resp, err = response.ResponseFromReader(conn, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, response.BadRequest, resp.StatusLine.StatusCode)
+}
+
+func TestTimeouts(t *testing.T) {
+	bodyErr := make(chan error, 1)
+	srv, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		switch req.RequestLine.RequestTarget {
+		case "/upload":
+			_, err := req.ReadBody()
+			bodyErr <- err
+		case "/slow":
+			time.Sleep(150 * time.Millisecond)
+		}
+		w.Write([]byte("ok"))
+	},
+		WithReadHeaderTimeout(100*time.Millisecond),
+		WithReadTimeout(400*time.Millisecond),
+		WithIdleTimeout(250*time.Millisecond),
+		WithWriteTimeout(100*time.Millisecond),
+	)
+	require.NoError(t, err)
+	defer srv.Close()
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+		require.NoError(t, err)
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		return conn
+	}
+
+	// Test: Partial headers get a 408 and the connection is closed
+	conn := dial()
+	defer conn.Close()
+	start := time.Now()
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: local")
+	require.NoError(t, err)
+	br := bufio.NewReader(conn)
+	resp, err := response.ResponseFromReader(br, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, response.RequestTimeout, resp.StatusLine.StatusCode)
+	assert.False(t, resp.KeepAlive())
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	rest, err := io.ReadAll(br)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+
+	// Test: Connection that sends nothing is dropped without a response
+	conn = dial()
+	defer conn.Close()
+	start = time.Now()
+	rest, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+
+	// Test: Idle kept alive connection is closed after IdleTimeout, not ReadHeaderTimeout
+	conn = dial()
+	defer conn.Close()
+	br = bufio.NewReader(conn)
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	resp, err = response.ResponseFromReader(br, "GET")
+	require.NoError(t, err)
+	assert.True(t, resp.KeepAlive())
+	start = time.Now()
+	rest, err = io.ReadAll(br)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+
+	// Test: Slow body runs into ReadTimeout and the connection is closed
+	conn = dial()
+	defer conn.Close()
+	_, err = io.WriteString(conn, "POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\nhello")
+	require.NoError(t, err)
+	select {
+	case err = <-bodyErr:
+		require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	case <-time.After(2 * time.Second):
+		t.Fatal("body was not cut off")
+	}
+	_, err = io.ReadAll(conn)
+	require.NoError(t, err)
+
+	// Test: Response that takes longer than WriteTimeout is not sent
+	conn = dial()
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	rest, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+}
+
+func TestPanicRecovery(t *testing.T) {
+	srv, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		switch req.RequestLine.RequestTarget {
+		case "/before":
+			panic("boom")
+		case "/buffered":
+			w.Write([]byte("partial"))
+			panic("boom")
+		case "/after":
+			w.WriteStatusLine(response.OK)
+			panic("boom")
+		}
+		w.Write([]byte("ok"))
+	})
+	require.NoError(t, err)
+	defer srv.Close()
+
+	send := func(target string) *bufio.Reader {
+		conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, err = io.WriteString(conn, "GET "+target+" HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		require.NoError(t, err)
+		return bufio.NewReader(conn)
+	}
+
+	// Test: Panic before anything was written gets a 500 with Connection: close
+	for _, target := range []string{"/before", "/buffered"} {
+		br := send(target)
+		resp, err := response.ResponseFromReader(br, "GET")
+		require.NoError(t, err, target)
+		assert.Equal(t, response.ServerError, resp.StatusLine.StatusCode, target)
+		connection, _ := resp.Headers.Get("Connection")
+		assert.Equal(t, "close", connection, target)
+		assert.NotContains(t, string(resp.Body), "partial", target)
+		rest, err := io.ReadAll(br)
+		require.NoError(t, err, target)
+		assert.Empty(t, rest, target)
+	}
+
+	// Test: Panic after the status line was sent only closes the connection
+	rest, err := io.ReadAll(send("/after"))
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", string(rest))
+
+	// Test: The server keeps serving
+	resp, err := response.ResponseFromReader(send("/"), "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(resp.Body))
+}
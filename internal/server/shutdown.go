@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// shutdownPollInterval is how often Shutdown checks whether all connections are gone
+const shutdownPollInterval = 50 * time.Millisecond
+
+/*
+Shutdown gracefully stops the server: it stops accepting connections, closes
+connections that are waiting for their next request and waits for the requests
+in flight to be answered. Connections still open when ctx expires are closed
+forcibly and ctx's error is returned.
+*/
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.Open.Store(false)
+	s.stopReloading()
+	var err error
+	if s.Listener != nil {
+		err = s.Listener.Close()
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.closeConns(true) == 0 {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			s.closeConns(false)
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+/*
+closeConns closes the tracked connections, only the idle ones if idleOnly is set.
+It returns how many connections are left open.
+*/
+func (s *Server) closeConns(idleOnly bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	left := 0
+	for conn, idle := range s.conns {
+		if idleOnly && !idle {
+			left++
+			continue
+		}
+		conn.Close()
+		delete(s.conns, conn)
+	}
+	return left
+}
+
+/*
+setIdle records whether conn is waiting for a request (idle) or serving one.
+It returns false if the server is shutting down and an idle conn should be closed instead.
+*/
+func (s *Server) setIdle(conn net.Conn, idle bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]bool)
+	}
+	s.conns[conn] = idle
+	return !idle || s.Open.Load()
+}
+
+// forget stops tracking conn once it has been closed.
+func (s *Server) forget(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+}
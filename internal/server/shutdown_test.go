@@ -0,0 +1,117 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TheBarnakhil/httpfromtcp/internal/request"
+	"github.com/TheBarnakhil/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	srv, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/slow" {
+			close(started)
+			<-release
+			w.Write([]byte(strings.Repeat("a", 10000)))
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	require.NoError(t, err)
+	defer srv.Close()
+	addr := srv.Listener.Addr().String()
+
+	idle, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer idle.Close()
+	_, err = io.WriteString(idle, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	idleReader := bufio.NewReader(idle)
+	_, err = response.ResponseFromReader(idleReader, "GET")
+	require.NoError(t, err)
+
+	busy, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer busy.Close()
+	_, err = io.WriteString(busy, "GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	<-started
+
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Shutdown(context.Background())
+	}()
+
+	// Test: Idle connection is closed right away
+	idle.SetReadDeadline(time.Now().Add(time.Second))
+	rest, err := io.ReadAll(idleReader)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+
+	// Test: New connections are refused
+	_, err = net.Dial("tcp", addr)
+	require.Error(t, err)
+
+	// Test: Request in flight is answered in full before the connection is closed
+	select {
+	case <-done:
+		t.Fatal("Shutdown returned with a request in flight")
+	default:
+	}
+	close(release)
+	busy.SetReadDeadline(time.Now().Add(2 * time.Second))
+	br := bufio.NewReader(busy)
+	resp, err := response.ResponseFromReader(br, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("a", 10000), string(resp.Body))
+	rest, err = io.ReadAll(br)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+	select {
+	case err = <-done:
+		require.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Shutdown did not return")
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	srv, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		close(started)
+		<-release
+	})
+	require.NoError(t, err)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	<-started
+
+	// Test: Expired context closes the connections left and returns its error
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = srv.Shutdown(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	rest, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// keyPair names the PEM files of a certificate and its private key.
+type keyPair struct {
+	certFile string
+	keyFile  string
+}
+
+/*
+ServeTLS is like Serve but speaks TLS, presenting the certificate in certFile with the
+private key in keyFile. WithCertificate adds more certificates, the one matching the
+server name the client asks for is presented, the first one if none does.
+WithMinTLSVersion and WithCipherSuites restrict what is negotiated, TLS 1.2 is the minimum
+by default.
+
+The certificates are read from disk again on SIGHUP, or when ReloadCertificates is called.
+Open connections keep the certificate they started with, new ones get the new certificates.
+*/
+func ServeTLS(port int, handlerFunc Handler, certFile, keyFile string, opts ...Option) (*Server, error) {
+	server := Server{
+		HandlerFunc: handlerFunc,
+		TLSConfig:   &tls.Config{MinVersion: tls.VersionTLS12},
+		keyPairs:    []keyPair{{certFile: certFile, keyFile: keyFile}},
+	}
+	for _, opt := range opts {
+		opt(&server)
+	}
+	if err := server.ReloadCertificates(); err != nil {
+		return &Server{}, err
+	}
+	server.TLSConfig.GetCertificate = server.getCertificate
+	server.TLSConfig.NextProtos = []string{"http/1.1"}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return &Server{}, errors.New("error: Error creating a listener")
+	}
+	server.Listener = tls.NewListener(listener, server.TLSConfig)
+	server.Open.Store(true)
+
+	server.stopReload = make(chan struct{})
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go server.reloadOnSignal(hup)
+
+	go server.listen()
+	return &server, nil
+}
+
+/*
+ReloadCertificates reads the certificates of a server started with ServeTLS from disk again.
+If one of them cannot be loaded, the server keeps presenting the ones it has.
+*/
+func (s *Server) ReloadCertificates() error {
+	certs := make([]tls.Certificate, 0, len(s.keyPairs))
+	for _, pair := range s.keyPairs {
+		cert, err := tls.LoadX509KeyPair(pair.certFile, pair.keyFile)
+		if err != nil {
+			return fmt.Errorf("error: Error loading certificate %s: %w", pair.certFile, err)
+		}
+		certs = append(certs, cert)
+	}
+	s.certificates.Store(&certs)
+	return nil
+}
+
+// getCertificate picks the certificate for a handshake, by the server name the client sent.
+func (s *Server) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certs := *s.certificates.Load()
+	for i := range certs {
+		if hello.SupportsCertificate(&certs[i]) == nil {
+			return &certs[i], nil
+		}
+	}
+	return &certs[0], nil
+}
+
+func (s *Server) reloadOnSignal(hup chan os.Signal) {
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-s.stopReload:
+			return
+		case <-hup:
+			if err := s.ReloadCertificates(); err != nil {
+				log.Printf("Error reloading certificates, keeping the current ones: %v", err)
+			} else {
+				log.Println("Certificates reloaded")
+			}
+		}
+	}
+}
+
+// stopReloading stops listening for SIGHUP once the server stops.
+func (s *Server) stopReloading() {
+	if s.stopReload != nil && s.reloadStopped.CompareAndSwap(false, true) {
+		close(s.stopReload)
+	}
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/TheBarnakhil/httpfromtcp/internal/request"
+	"github.com/TheBarnakhil/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeTLS(t *testing.T) {
+	dir := t.TempDir()
+	certA, keyA := writeCertificate(t, dir, "a.test", 1)
+	certB, keyB := writeCertificate(t, dir, "b.test", 2)
+
+	srv, err := ServeTLS(0, func(w *response.Writer, req *request.Request) {
+		w.Write([]byte(fmt.Sprintf("%v %s", req.TLS != nil, req.TLS.ServerName)))
+	}, certA, keyA, WithCertificate(certB, keyB))
+	require.NoError(t, err)
+	defer srv.Close()
+	addr := srv.Listener.Addr().String()
+
+	dial := func(serverName string) (*tls.Conn, error) {
+		return tls.Dial("tcp", addr, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+	}
+	presented := func(serverName string) *x509.Certificate {
+		conn, err := dial(serverName)
+		require.NoError(t, err)
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0]
+	}
+	get := func(conn net.Conn) string {
+		_, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		require.NoError(t, err)
+		resp, err := response.ResponseFromReader(conn, "GET")
+		require.NoError(t, err)
+		return string(resp.Body)
+	}
+
+	// Test: Certificate is picked by server name, the first one without a match
+	assert.Equal(t, []string{"a.test"}, presented("a.test").DNSNames)
+	assert.Equal(t, []string{"b.test"}, presented("b.test").DNSNames)
+	assert.Equal(t, []string{"a.test"}, presented("c.test").DNSNames)
+
+	// Test: Handler sees the connection state
+	conn, err := dial("b.test")
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, "true b.test", get(conn))
+
+	// Test: Certificates are reloaded on SIGHUP and open connections keep working
+	if runtime.GOOS != "windows" {
+		writeCertificate(t, dir, "b.test", 3)
+		process, err := os.FindProcess(os.Getpid())
+		require.NoError(t, err)
+		require.NoError(t, process.Signal(syscall.SIGHUP))
+		require.Eventually(t, func() bool {
+			return presented("b.test").SerialNumber.Int64() == 3
+		}, time.Second, 10*time.Millisecond)
+		assert.Equal(t, "true b.test", get(conn))
+	}
+
+	// Test: Certificates that cannot be loaded leave the current ones in place
+	require.NoError(t, os.WriteFile(certB, []byte("garbage"), 0o600))
+	require.Error(t, srv.ReloadCertificates())
+	assert.Equal(t, []string{"b.test"}, presented("b.test").DNSNames)
+
+	// Test: Missing certificate
+	_, err = ServeTLS(0, nil, filepath.Join(dir, "missing.pem"), keyA)
+	require.Error(t, err)
+}
+
+func TestTLSVersions(t *testing.T) {
+	dir := t.TempDir()
+	cert, key := writeCertificate(t, dir, "a.test", 1)
+	srv, err := ServeTLS(0, func(w *response.Writer, _ *request.Request) {}, cert, key,
+		WithMinTLSVersion(tls.VersionTLS13))
+	require.NoError(t, err)
+	defer srv.Close()
+
+	// Test: Clients below the minimum version are refused
+	_, err = tls.Dial("tcp", srv.Listener.Addr().String(), &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12})
+	require.Error(t, err)
+
+	conn, err := tls.Dial("tcp", srv.Listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, uint16(tls.VersionTLS13), conn.ConnectionState().Version)
+
+	// Test: Cipher suites are restricted below TLS 1.3
+	srv, err = ServeTLS(0, func(w *response.Writer, _ *request.Request) {}, cert, key,
+		WithCipherSuites(tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384))
+	require.NoError(t, err)
+	defer srv.Close()
+	_, err = tls.Dial("tcp", srv.Listener.Addr().String(), &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12,
+		CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}})
+	require.Error(t, err)
+	conn, err = tls.Dial("tcp", srv.Listener.Addr().String(), &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12})
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, conn.ConnectionState().CipherSuite)
+}
+
+// writeCertificate writes a self-signed certificate for name to dir and returns the paths of it and its key.
+func writeCertificate(t *testing.T, dir, name string, serial int64) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, name+".pem")
+	keyFile := filepath.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile
+}